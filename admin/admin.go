@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/reddec/git-pipe/internal"
+	"github.com/reddec/git-pipe/pipe"
+	"go.uber.org/zap"
+)
+
+const (
+	PathPrefix = "/api/repos"
+)
+
+// Manager of pipelines.
+type Manager interface {
+	// List status of all pipelines.
+	List() []pipe.Status
+	// Status of single pipeline.
+	Status(name string) (pipe.Status, error)
+	// Redeploy package even if nothing changed.
+	Redeploy(name string) error
+	// Stop pipeline.
+	Stop(name string) error
+	// Start stopped pipeline.
+	Start(name string) error
+	// Backup pipeline volumes.
+	Backup(ctx context.Context, name string) error
+	// Restore pipeline volumes.
+	Restore(ctx context.Context, name string) error
+}
+
+// New management API handler. Empty token disables authorization.
+//
+//	GET  /api/repos                 - list of repos statuses
+//	GET  /api/repos/<name>          - status of single repo
+//	POST /api/repos/<name>/redeploy - force redeploy
+//	POST /api/repos/<name>/stop     - stop repo
+//	POST /api/repos/<name>/start    - start stopped repo
+//	POST /api/repos/<name>/backup   - backup volumes
+//	POST /api/repos/<name>/restore  - restore volumes from backup
+func New(manager Manager, token string) http.Handler {
+	return &server{manager: manager, token: token}
+}
+
+type server struct {
+	manager Manager
+	token   string
+}
+
+func (srv *server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	logger := internal.SubLogger(request.Context(), "admin").With(zap.String("method", request.Method), zap.String("path", request.URL.Path))
+	if !srv.authorized(request) {
+		logger.Info("unauthorized request")
+		sendError(writer, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(request.URL.Path, PathPrefix), "/")
+	if !strings.HasPrefix(request.URL.Path, PathPrefix) {
+		sendError(writer, http.StatusNotFound, errUnknownEndpoint)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "" && request.Method == http.MethodGet:
+		sendJSON(writer, http.StatusOK, srv.manager.List())
+	case len(parts) == 1 && request.Method == http.MethodGet:
+		status, err := srv.manager.Status(parts[0])
+		if err != nil {
+			sendError(writer, errorCode(err), err)
+			return
+		}
+		sendJSON(writer, http.StatusOK, status)
+	case len(parts) == 2 && request.Method == http.MethodPost: //nolint:gomnd
+		err := srv.action(request.Context(), parts[0], parts[1])
+		if err != nil {
+			logger.Warn("action failed", zap.Error(err))
+			sendError(writer, errorCode(err), err)
+			return
+		}
+		logger.Info("action complete")
+		status, _ := srv.manager.Status(parts[0])
+		sendJSON(writer, http.StatusOK, status)
+	default:
+		sendError(writer, http.StatusNotFound, errUnknownEndpoint)
+	}
+}
+
+func (srv *server) action(ctx context.Context, name string, action string) error {
+	switch action {
+	case "redeploy":
+		return srv.manager.Redeploy(name)
+	case "stop":
+		return srv.manager.Stop(name)
+	case "start":
+		return srv.manager.Start(name)
+	case "backup":
+		return srv.manager.Backup(ctx, name)
+	case "restore":
+		return srv.manager.Restore(ctx, name)
+	default:
+		return errUnknownEndpoint
+	}
+}
+
+func (srv *server) authorized(request *http.Request) bool {
+	if srv.token == "" {
+		return true
+	}
+	header := request.Header.Get("Authorization")
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	return subtle.ConstantTimeCompare([]byte(token), []byte(srv.token)) == 1
+}
+
+var (
+	errUnauthorized    = errors.New("unauthorized")
+	errUnknownEndpoint = errors.New("unknown endpoint")
+)
+
+func errorCode(err error) int {
+	switch {
+	case errors.Is(err, pipe.ErrUnknownPipeline), errors.Is(err, errUnknownEndpoint):
+		return http.StatusNotFound
+	case errors.Is(err, pipe.ErrPipelineStopped), errors.Is(err, pipe.ErrVolumesUnknown):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type errorMessage struct {
+	Error string `json:"error"`
+}
+
+func sendError(writer http.ResponseWriter, code int, err error) {
+	sendJSON(writer, code, errorMessage{Error: err.Error()})
+}
+
+func sendJSON(writer http.ResponseWriter, code int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+	_ = json.NewEncoder(writer).Encode(value)
+}
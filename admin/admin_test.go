@@ -0,0 +1,122 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/reddec/git-pipe/admin"
+	"github.com/reddec/git-pipe/pipe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmin(t *testing.T) {
+	manager := &testManager{statuses: map[string]pipe.Status{
+		"app": {Name: "app", State: pipe.StateRunning, Version: "abc"},
+		"api": {Name: "api", State: pipe.StateStopped},
+	}}
+	handler := admin.New(manager, "secret")
+
+	t.Run("unauthorized", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/repos", nil))
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, "/api/repos", nil)
+		rq.Header.Set("Authorization", "Bearer secret")
+		handler.ServeHTTP(rr, rq)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var list []pipe.Status
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&list))
+		assert.Len(t, list, 2)
+	})
+
+	t.Run("status", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, "/api/repos/app", nil)
+		rq.Header.Set("Authorization", "Bearer secret")
+		handler.ServeHTTP(rr, rq)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var status pipe.Status
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&status))
+		assert.Equal(t, "abc", status.Version)
+	})
+
+	t.Run("unknown repo", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, "/api/repos/unknown", nil)
+		rq.Header.Set("Authorization", "Bearer secret")
+		handler.ServeHTTP(rr, rq)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("actions", func(t *testing.T) {
+		for _, action := range []string{"redeploy", "stop", "start", "backup", "restore"} {
+			rr := httptest.NewRecorder()
+			rq := httptest.NewRequest(http.MethodPost, "/api/repos/app/"+action, nil)
+			rq.Header.Set("Authorization", "Bearer secret")
+			handler.ServeHTTP(rr, rq)
+			assert.Equal(t, http.StatusOK, rr.Code, action)
+		}
+		assert.Equal(t, []string{"redeploy", "stop", "start", "backup", "restore"}, manager.actions)
+	})
+
+	t.Run("redeploy stopped", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodPost, "/api/repos/api/redeploy", nil)
+		rq.Header.Set("Authorization", "Bearer secret")
+		handler.ServeHTTP(rr, rq)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+}
+
+type testManager struct {
+	statuses map[string]pipe.Status
+	actions  []string
+}
+
+func (tm *testManager) List() []pipe.Status {
+	var ans []pipe.Status
+	for _, s := range tm.statuses {
+		ans = append(ans, s)
+	}
+	return ans
+}
+
+func (tm *testManager) Status(name string) (pipe.Status, error) {
+	s, ok := tm.statuses[name]
+	if !ok {
+		return s, pipe.ErrUnknownPipeline
+	}
+	return s, nil
+}
+
+func (tm *testManager) Redeploy(name string) error {
+	s, err := tm.Status(name)
+	if err != nil {
+		return err
+	}
+	if s.State == pipe.StateStopped {
+		return pipe.ErrPipelineStopped
+	}
+	return tm.do("redeploy")
+}
+
+func (tm *testManager) Stop(name string) error { return tm.do("stop") }
+
+func (tm *testManager) Start(name string) error { return tm.do("start") }
+
+func (tm *testManager) Backup(ctx context.Context, name string) error { return tm.do("backup") }
+
+func (tm *testManager) Restore(ctx context.Context, name string) error { return tm.do("restore") }
+
+func (tm *testManager) do(action string) error {
+	tm.actions = append(tm.actions, action)
+	return nil
+}
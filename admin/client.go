@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/reddec/git-pipe/pipe"
+)
+
+// NewClient for management API. Empty token means no authorization.
+func NewClient(baseURL string, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		client:  http.DefaultClient,
+	}
+}
+
+// Client for management API.
+type Client struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// List status of all repos.
+func (cl *Client) List(ctx context.Context) ([]pipe.Status, error) {
+	var list []pipe.Status
+	return list, cl.do(ctx, http.MethodGet, PathPrefix, &list)
+}
+
+// Status of single repo.
+func (cl *Client) Status(ctx context.Context, name string) (pipe.Status, error) {
+	var status pipe.Status
+	return status, cl.do(ctx, http.MethodGet, PathPrefix+"/"+url.PathEscape(name), &status)
+}
+
+// Action invokes action (redeploy, stop, start, backup, restore) for the repo and returns new status.
+func (cl *Client) Action(ctx context.Context, name string, action string) (pipe.Status, error) {
+	var status pipe.Status
+	return status, cl.do(ctx, http.MethodPost, PathPrefix+"/"+url.PathEscape(name)+"/"+action, &status)
+}
+
+func (cl *Client) do(ctx context.Context, method string, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, cl.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	if cl.token != "" {
+		req.Header.Set("Authorization", "Bearer "+cl.token)
+	}
+	res, err := cl.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("execute request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var msg errorMessage
+		_ = json.NewDecoder(res.Body).Decode(&msg)
+		return &ErrRemote{Code: res.StatusCode, Message: msg.Error}
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// ErrRemote returned by management API.
+type ErrRemote struct {
+	Code    int
+	Message string
+}
+
+func (er *ErrRemote) Error() string {
+	return fmt.Sprintf("remote error %d: %s", er.Code, er.Message)
+}
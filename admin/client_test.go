@@ -0,0 +1,96 @@
+package admin_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/reddec/git-pipe/admin"
+	"github.com/reddec/git-pipe/pipe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	manager := &testManager{statuses: map[string]pipe.Status{
+		"app": {Name: "app", State: pipe.StateRunning, Version: "abc"},
+		"api": {Name: "api", State: pipe.StateStopped},
+	}}
+	server := httptest.NewServer(admin.New(manager, "secret"))
+	defer server.Close()
+
+	ctx := context.Background()
+	client := admin.NewClient(server.URL+"/", "secret")
+
+	t.Run("list", func(t *testing.T) {
+		list, err := client.List(ctx)
+		require.NoError(t, err)
+		assert.Len(t, list, 2)
+	})
+
+	t.Run("status", func(t *testing.T) {
+		status, err := client.Status(ctx, "app")
+		require.NoError(t, err)
+		assert.Equal(t, pipe.Status{Name: "app", State: pipe.StateRunning, Version: "abc"}, status)
+	})
+
+	t.Run("actions", func(t *testing.T) {
+		manager.actions = nil
+		for _, action := range []string{"redeploy", "backup", "restore"} {
+			status, err := client.Action(ctx, "app", action)
+			require.NoError(t, err, action)
+			assert.Equal(t, "app", status.Name)
+		}
+		assert.Equal(t, []string{"redeploy", "backup", "restore"}, manager.actions)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		for _, token := range []string{"", "wrong"} {
+			_, err := admin.NewClient(server.URL, token).List(ctx)
+			assertRemoteError(t, err, http.StatusUnauthorized, "unauthorized")
+		}
+	})
+
+	t.Run("unknown repo", func(t *testing.T) {
+		_, err := client.Status(ctx, "unknown")
+		assertRemoteError(t, err, http.StatusNotFound, pipe.ErrUnknownPipeline.Error())
+	})
+
+	t.Run("redeploy stopped", func(t *testing.T) {
+		_, err := client.Action(ctx, "api", "redeploy")
+		assertRemoteError(t, err, http.StatusConflict, pipe.ErrPipelineStopped.Error())
+	})
+}
+
+func TestClient_proxyError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "Bearer secret", request.Header.Get("Authorization"))
+		http.Error(writer, "bad gateway", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	_, err := admin.NewClient(server.URL, "secret").Action(context.Background(), "app", "backup")
+	assertRemoteError(t, err, http.StatusBadGateway, "")
+}
+
+func TestClient_noToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Empty(t, request.Header.Get("Authorization"))
+		assert.Equal(t, "/api/repos/my%20app", request.URL.EscapedPath())
+		_, _ = writer.Write([]byte(`{"name": "my app"}`))
+	}))
+	defer server.Close()
+
+	status, err := admin.NewClient(server.URL, "").Status(context.Background(), "my app")
+	require.NoError(t, err)
+	assert.Equal(t, "my app", status.Name)
+}
+
+func assertRemoteError(t *testing.T, err error, code int, message string) {
+	var remoteErr *admin.ErrRemote
+	require.True(t, errors.As(err, &remoteErr), "expected remote error, got %v", err)
+	assert.Equal(t, code, remoteErr.Code)
+	assert.Contains(t, remoteErr.Message, message)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/reddec/git-pipe/admin"
+	"github.com/reddec/git-pipe/pipe"
+)
+
+type Remote struct {
+	URL   string `long:"url" short:"u" env:"ADMIN_URL" description:"Management API URL of running git-pipe" default:"http://127.0.0.1:8082"`
+	Token string `long:"token" short:"t" env:"ADMIN_TOKEN" description:"Management API token"`
+}
+
+func (rm Remote) client() *admin.Client {
+	return admin.NewClient(rm.URL, rm.Token)
+}
+
+type CommandStatus struct {
+	Remote
+	Args struct {
+		Repos []string `positional-arg-name:"repo" description:"Repo names. Empty means all repos"`
+	} `positional-args:"true"`
+}
+
+func (cmd *CommandStatus) Execute([]string) error {
+	client := cmd.client()
+	var list []pipe.Status
+	if len(cmd.Args.Repos) == 0 {
+		all, err := client.List(global)
+		if err != nil {
+			return fmt.Errorf("list repos: %w", err)
+		}
+		list = all
+	}
+	for _, name := range cmd.Args.Repos {
+		status, err := client.Status(global, name)
+		if err != nil {
+			return fmt.Errorf("get status of %s: %w", name, err)
+		}
+		list = append(list, status)
+	}
+	printStatus(list...)
+	return nil
+}
+
+type CommandRedeploy struct {
+	Remote
+	Args repoArgs `positional-args:"true"`
+}
+
+func (cmd *CommandRedeploy) Execute([]string) error {
+	return remoteAction(cmd.client(), cmd.Args.Repo, "redeploy")
+}
+
+type CommandBackup struct {
+	Remote
+	Args repoArgs `positional-args:"true"`
+}
+
+func (cmd *CommandBackup) Execute([]string) error {
+	return remoteAction(cmd.client(), cmd.Args.Repo, "backup")
+}
+
+type CommandRestore struct {
+	Remote
+	Args repoArgs `positional-args:"true"`
+}
+
+func (cmd *CommandRestore) Execute([]string) error {
+	return remoteAction(cmd.client(), cmd.Args.Repo, "restore")
+}
+
+type repoArgs struct {
+	Repo string `positional-arg-name:"repo" required:"yes" description:"Repo name"`
+}
+
+func remoteAction(client *admin.Client, repo string, action string) error {
+	status, err := client.Action(global, repo, action)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", action, repo, err)
+	}
+	printStatus(status)
+	return nil
+}
+
+func printStatus(list ...pipe.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+	defer w.Flush()
+	_, _ = fmt.Fprintln(w, "NAME\tSTATE\tVERSION\tDEPLOYED\tDOMAINS\tERROR")
+	for _, status := range list {
+		version := status.Version
+		if len(version) > 8 { //nolint:gomnd
+			version = version[:8]
+		}
+		var deployed string
+		if !status.Deployed.IsZero() {
+			deployed = status.Deployed.Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", status.Name, status.State, version, deployed, strings.Join(status.Domains, ","), status.Error)
+	}
+}
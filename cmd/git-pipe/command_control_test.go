@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/reddec/git-pipe/admin"
+	"github.com/reddec/git-pipe/pipe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControlCommands(t *testing.T) {
+	global = context.Background()
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests = append(requests, request.Method+" "+request.URL.Path)
+		writer.Header().Set("Content-Type", "application/json")
+		switch {
+		case request.Header.Get("Authorization") != "Bearer secret":
+			writer.WriteHeader(http.StatusUnauthorized)
+			_, _ = writer.Write([]byte(`{"error": "unauthorized"}`))
+		case request.URL.Path == admin.PathPrefix:
+			_ = json.NewEncoder(writer).Encode([]pipe.Status{{Name: "app", State: pipe.StateRunning}, {Name: "api", State: pipe.StateStopped}})
+		case request.URL.Path == admin.PathPrefix+"/unknown" || request.URL.Path == admin.PathPrefix+"/unknown/backup":
+			writer.WriteHeader(http.StatusNotFound)
+			_, _ = writer.Write([]byte(`{"error": "unknown pipeline"}`))
+		default:
+			_ = json.NewEncoder(writer).Encode(pipe.Status{Name: "app", State: pipe.StateRunning, Version: "0123456789abcdef"})
+		}
+	}))
+	defer server.Close()
+
+	remote := Remote{URL: server.URL, Token: "secret"}
+
+	t.Run("status of all repos", func(t *testing.T) {
+		requests = nil
+		output := captureStdout(t, func() {
+			require.NoError(t, (&CommandStatus{Remote: remote}).Execute(nil))
+		})
+		assert.Equal(t, []string{"GET /api/repos"}, requests)
+		assert.Contains(t, output, "app")
+		assert.Contains(t, output, "api")
+	})
+
+	t.Run("status of selected repos", func(t *testing.T) {
+		requests = nil
+		cmd := &CommandStatus{Remote: remote}
+		cmd.Args.Repos = []string{"app"}
+		output := captureStdout(t, func() {
+			require.NoError(t, cmd.Execute(nil))
+		})
+		assert.Equal(t, []string{"GET /api/repos/app"}, requests)
+		assert.Contains(t, output, "01234567")
+		assert.NotContains(t, output, "0123456789abcdef", "version is shortened")
+	})
+
+	t.Run("actions", func(t *testing.T) {
+		requests = nil
+		captureStdout(t, func() {
+			require.NoError(t, (&CommandRedeploy{Remote: remote, Args: repoArgs{Repo: "app"}}).Execute(nil))
+			require.NoError(t, (&CommandBackup{Remote: remote, Args: repoArgs{Repo: "app"}}).Execute(nil))
+			require.NoError(t, (&CommandRestore{Remote: remote, Args: repoArgs{Repo: "app"}}).Execute(nil))
+		})
+		assert.Equal(t, []string{
+			"POST /api/repos/app/redeploy",
+			"POST /api/repos/app/backup",
+			"POST /api/repos/app/restore",
+		}, requests)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		err := (&CommandStatus{Remote: Remote{URL: server.URL, Token: "wrong"}}).Execute(nil)
+		var remoteErr *admin.ErrRemote
+		require.ErrorAs(t, err, &remoteErr)
+		assert.Equal(t, http.StatusUnauthorized, remoteErr.Code)
+	})
+
+	t.Run("unknown repo", func(t *testing.T) {
+		cmd := &CommandStatus{Remote: remote}
+		cmd.Args.Repos = []string{"unknown"}
+		err := cmd.Execute(nil)
+		var remoteErr *admin.ErrRemote
+		require.ErrorAs(t, err, &remoteErr)
+		assert.Equal(t, http.StatusNotFound, remoteErr.Code)
+		assert.Equal(t, "unknown pipeline", remoteErr.Message)
+
+		err = (&CommandBackup{Remote: remote, Args: repoArgs{Repo: "unknown"}}).Execute(nil)
+		require.ErrorAs(t, err, &remoteErr)
+		assert.Contains(t, err.Error(), "backup unknown")
+	})
+}
+
+// captureStdout returns everything printed to stdout by fn.
+func captureStdout(t *testing.T, fn func()) string {
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	fn()
+
+	require.NoError(t, writer.Close())
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	return string(data)
+}
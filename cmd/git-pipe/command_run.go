@@ -4,14 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/docker/docker/client"
 	"github.com/hashicorp/go-multierror"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/reddec/git-pipe/admin"
 	"github.com/reddec/git-pipe/backup"
 	"github.com/reddec/git-pipe/backup/filebackup"
 	"github.com/reddec/git-pipe/backup/nobackup"
@@ -21,18 +26,26 @@ import (
 	"github.com/reddec/git-pipe/core/dns/noregister"
 	"github.com/reddec/git-pipe/core/dns/singlehost"
 	"github.com/reddec/git-pipe/core/event"
+	"github.com/reddec/git-pipe/core/forward"
 	"github.com/reddec/git-pipe/core/ingress"
 	"github.com/reddec/git-pipe/core/ingress/dummy"
 	"github.com/reddec/git-pipe/core/ingress/embedded"
 	"github.com/reddec/git-pipe/core/network"
 	"github.com/reddec/git-pipe/core/storage"
+	"github.com/reddec/git-pipe/cryptor"
 	"github.com/reddec/git-pipe/cryptor/symmetric"
 	"github.com/reddec/git-pipe/internal"
 	"github.com/reddec/git-pipe/pipe"
 	"github.com/reddec/git-pipe/remote"
+	"github.com/reddec/git-pipe/remote/archive"
 	"github.com/reddec/git-pipe/remote/git"
+	"github.com/reddec/git-pipe/remote/gogit"
+	"github.com/reddec/git-pipe/remote/local"
+	"github.com/reddec/git-pipe/remote/oci"
+	"github.com/reddec/git-pipe/webhook"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v2"
 )
 
 type CommandRun struct {
@@ -50,9 +63,15 @@ type CommandRun struct {
 	LogLevel         logLevel      `long:"log-level" env:"LOG_LEVEL" description:"Log level" default:"debug"`
 	Provider         string        `long:"provider" short:"p" env:"PROVIDER" description:"DNS provider for auto registration" choice:"cloudflare"`
 	Cloudflare       cf.Config     `group:"Cloudflare config" namespace:"cloudflare" env-namespace:"CLOUDFLARE"`
+	Config           string        `long:"config" short:"c" env:"CONFIG" description:"YAML file with repositories and per-repo overrides"`
+	Webhook          Webhook       `group:"Webhook config" namespace:"webhook" env-namespace:"WEBHOOK"`
+	Admin            Admin         `group:"Admin API config" namespace:"admin" env-namespace:"ADMIN"`
+	Metrics          Metrics       `group:"Metrics config" namespace:"metrics" env-namespace:"METRICS"`
+	Forward          Forward       `group:"TCP and UDP forwarding config" namespace:"forward" env-namespace:"FORWARD"`
+	Git              Git           `group:"Git config" namespace:"git" env-namespace:"GIT"`
 
 	Args struct {
-		Repos []string `positional-arg-name:"git-url" required:"1" description:"remote git URL to poll with optional branch/tag name after hash"`
+		Repos []string `positional-arg-name:"git-url" description:"remote git URL to poll with optional branch/tag name after hash"`
 	} `positional-args:"true"`
 }
 
@@ -68,6 +87,52 @@ type Router struct {
 	JWT         string `long:"jwt" env:"JWT" description:"Define JWT secret and enable JWT-based authorization"`
 }
 
+type Webhook struct {
+	Bind   string `long:"bind" env:"BIND" description:"Address to where bind webhook HTTP server. Empty means disabled"`
+	Secret string `long:"secret" env:"SECRET" description:"Secret to verify webhook signature (or token for GitLab). Empty means no verification"`
+}
+
+type Admin struct {
+	Bind  string `long:"bind" env:"BIND" description:"Address to where bind management API. Empty means disabled"`
+	Token string `long:"token" env:"TOKEN" description:"Bearer token for management API. Empty means no authorization"`
+}
+
+type Metrics struct {
+	Bind string `long:"bind" env:"BIND" description:"Address to where bind Prometheus metrics endpoint (/metrics). Empty means disabled"`
+}
+
+type Forward struct {
+	Host  string `long:"host" env:"HOST" description:"Host address where bind forwarded TCP and UDP ports" default:"127.0.0.1"`
+	Ports string `long:"ports" env:"PORTS" description:"Range of host ports (ex: 5000-5999) allowed for TCP and UDP forwarding. Empty means disabled"`
+}
+
+type Git struct {
+	Driver          string `long:"driver" env:"DRIVER" description:"Git implementation: cli (git binary) or native (built-in, does not require git)" default:"cli" choice:"cli" choice:"native"`
+	SSHKey          string `long:"ssh-key" env:"SSH_KEY" description:"Path to SSH private key. Empty means SSH agent or inherited configuration"`
+	SSHKeyPassword  string `long:"ssh-key-password" env:"SSH_KEY_PASSWORD" description:"Passphrase for SSH private key (native driver only)"`
+	KnownHosts      string `long:"known-hosts" env:"KNOWN_HOSTS" description:"Path to known_hosts file. Empty means SSH_KNOWN_HOSTS or ~/.ssh/known_hosts for native driver and inherited configuration for cli"`
+	InsecureHostKey bool   `long:"insecure-ignore-host-key" env:"INSECURE_IGNORE_HOST_KEY" description:"Do not verify SSH host keys (native driver only). Insecure: allows MITM"`
+	Username        string `long:"username" env:"USERNAME" description:"Username for HTTP(S) remotes"`
+	Password        string `long:"password" env:"PASSWORD" description:"Password or token for HTTP(S) remotes"`
+	Submodules      bool   `long:"submodules" env:"SUBMODULES" description:"Fetch and update submodules recursively"`
+	LFS             bool   `long:"lfs" env:"LFS" description:"Pull LFS objects (cli driver only, requires git-lfs)"`
+	AllowedSigners  string `long:"allowed-signers" env:"ALLOWED_SIGNERS" description:"Deploy only commits with SSH signature by key from allowed signers file (cli driver only)"`
+	GPGHome         string `long:"gpg-home" env:"GPG_HOME" description:"Deploy only commits with GPG signature by key from keyring in the directory (cli driver only)"`
+	Depth           int    `long:"depth" env:"DEPTH" description:"Fetch only the last commits of branch or tags (native driver only). 0 means full history"`
+}
+
+func (g Git) credentials() remote.Credentials {
+	return remote.Credentials{
+		SSHKey:         g.SSHKey,
+		SSHKeyPassword: g.SSHKeyPassword,
+		KnownHosts:     g.KnownHosts,
+		Username:       g.Username,
+		Password:       g.Password,
+
+		InsecureIgnoreHostKey: g.InsecureHostKey,
+	}
+}
+
 func (cmd *CommandRun) Execute([]string) error {
 	if cmd.Router.Domain == "" {
 		name, err := os.Hostname()
@@ -87,7 +152,12 @@ func (cmd *CommandRun) Execute([]string) error {
 	ctx, cancel := context.WithCancel(global)
 	defer cancel()
 
-	backupProvider, err := cmd.createBackupProvider()
+	repos, err := cmd.repos()
+	if err != nil {
+		return fmt.Errorf("load repos: %w", err)
+	}
+
+	backupProvider, err := cmd.createBackupProvider(cmd.Backup)
 	if err != nil {
 		return fmt.Errorf("initialize storage: %w", err)
 	}
@@ -117,6 +187,7 @@ func (cmd *CommandRun) Execute([]string) error {
 	var (
 		ingressImpl core.Ingress
 		router      *embedded.Router
+		policy      *embedded.Policy
 	)
 	if cmd.Router.Dummy {
 		ingressImpl = dummy.New()
@@ -128,19 +199,25 @@ func (cmd *CommandRun) Execute([]string) error {
 			resolver = embedded.ByDomain(cmd.Router.Domain)
 		}
 
-		var chain []embedded.RouteHandler
+		var auth embedded.RouteHandler
 		if cmd.Router.JWT != "" {
-			chain = append(chain, embedded.JWT(cmd.Router.JWT))
+			auth = embedded.JWT(cmd.Router.JWT)
 		}
-		chain = append(chain, embedded.Proxy(dockerNetwork))
-		router = embedded.New(resolver, chain...)
+		policy = embedded.NewPolicy(auth)
+		router = embedded.New(resolver, policy, embedded.Files(), embedded.Proxy(dockerNetwork))
 		router.Index(!cmd.Router.NoIndex)
 		ingressImpl = ingress.New(router)
 	}
 
+	forwardPorts, err := forward.ParseRange(cmd.Forward.Ports)
+	if err != nil {
+		return fmt.Errorf("parse forward ports: %w", err)
+	}
+
 	env := core.Base{
 		DNS:     dnsProvider,
 		Ingress: ingressImpl,
+		Forward: forward.New(cmd.Forward.Host, forwardPorts, dockerNetwork),
 		Backup:  storage.New(backupProvider, docker, encryption, "", "local", cmd.BackupInterval),
 		Network: dockerNetwork,
 		Docker:  docker,
@@ -155,51 +232,299 @@ func (cmd *CommandRun) Execute([]string) error {
 		})
 	}
 
-	environ, err := cmd.environment()
+	factory := &pipelineFactory{
+		cmd:        cmd,
+		base:       env,
+		docker:     docker,
+		encryption: encryption,
+		policy:     policy,
+	}
+
+	pipelines, err := factory.Build(repos)
+	if err != nil {
+		return fmt.Errorf("create pipelines: %w", err)
+	}
+
+	manager := pipe.NewManager(ctx)
+	manager.Sync(pipelines)
+
+	if cmd.Webhook.Bind != "" {
+		if cmd.Webhook.Secret == "" {
+			logger.Warn("webhook secret is not set - anyone can trigger polling")
+		}
+		wg.Go(func() error {
+			defer cancel()
+			return embedded.Run(ctx, cmd.Webhook.Bind, webhook.New(cmd.Webhook.Secret, manager))
+		})
+	}
+
+	if cmd.Admin.Bind != "" {
+		if cmd.Admin.Token == "" {
+			logger.Warn("admin token is not set - anyone with access to admin address can manage repos")
+		}
+		wg.Go(func() error {
+			defer cancel()
+			return embedded.Run(ctx, cmd.Admin.Bind, admin.New(manager, cmd.Admin.Token))
+		})
+	}
+
+	if cmd.Metrics.Bind != "" {
+		wg.Go(func() error {
+			defer cancel()
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+			return embedded.Run(ctx, cmd.Metrics.Bind, mux)
+		})
+	}
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+LOOP:
+	for {
+		select {
+		case <-ctx.Done():
+			break LOOP
+		case <-reload:
+			if err := cmd.reload(factory, manager); err != nil {
+				logger.Warn("reload failed", zap.Error(err))
+			}
+		}
+	}
+
+	manager.Close()
+	return wg.Wait().ErrorOrNil()
+}
+
+// reload repositories and apply changes.
+func (cmd *CommandRun) reload(factory *pipelineFactory, manager *pipe.Manager) error {
+	repos, err := cmd.repos()
+	if err != nil {
+		return fmt.Errorf("load repos: %w", err)
+	}
+	pipelines, err := factory.Build(repos)
 	if err != nil {
-		return fmt.Errorf("read environment: %w", err)
+		return fmt.Errorf("create pipelines: %w", err)
 	}
+	started, stopped := manager.Sync(pipelines)
+	zap.L().Info("reloaded", zap.Strings("started", started), zap.Strings("stopped", stopped))
+	return nil
+}
+
+var (
+	errUnknownProvider        = errors.New("unknown provider")
+	errUnknownBackupProtocol  = errors.New("unknown backup protocol")
+	errNoRepos                = errors.New("no repositories defined")
+	errRepoURLRequired        = errors.New("repo URL required")
+	errDuplicatedName         = errors.New("duplicated repo name")
+	errEnvNotSet              = errors.New("environment variable not set")
+	errLFSNotSupported        = errors.New("LFS is not supported by native git driver")
+	errSignaturesNotSupported = errors.New("signature verification is not supported by native git driver")
+	errSignaturesNotGit       = errors.New("signature verification is supported only for git repositories")
+)
 
+// repos from config file and positional arguments.
+func (cmd CommandRun) repos() ([]RepoConfig, error) {
+	var repos []RepoConfig
+	if cmd.Config != "" {
+		cfg, err := LoadConfig(cmd.Config)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, cfg.Repos...)
+	}
 	for _, repo := range cmd.Args.Repos {
-		source, err := git.FromURL(repo)
+		repos = append(repos, RepoConfig{URL: repo})
+	}
+	if len(repos) == 0 {
+		return nil, errNoRepos
+	}
+	return repos, nil
+}
+
+// pipelineFactory creates pipelines definitions from repos configuration.
+type pipelineFactory struct {
+	cmd        *CommandRun
+	base       core.Base
+	docker     *client.Client
+	encryption cryptor.Cryptor
+	policy     *embedded.Policy  // optional
+	revisions  map[string]string // pipeline name -> revision from the last build
+}
+
+// Build pipelines and update per-repo authorization policies. Policies of repos removed since the last build are
+// removed too.
+func (pf *pipelineFactory) Build(repos []RepoConfig) ([]pipe.Pipeline, error) {
+	environ, err := pf.cmd.environment()
+	if err != nil {
+		return nil, fmt.Errorf("read environment: %w", err)
+	}
+
+	var pipelines = make([]pipe.Pipeline, 0, len(repos))
+	var names = make(map[string]bool, len(repos))
+	for _, repo := range repos {
+		credentials := pf.cmd.Git.credentials()
+		if repo.Credentials != nil {
+			credentials, err = repo.Credentials.Apply(credentials)
+			if err != nil {
+				return nil, fmt.Errorf("credentials for repo %s: %w", repo.URL, err)
+			}
+		}
+
+		options := git.Options{
+			Credentials:    credentials,
+			Submodules:     pf.cmd.Git.Submodules,
+			LFS:            pf.cmd.Git.LFS,
+			AllowedSigners: pf.cmd.Git.AllowedSigners,
+			GPGHome:        pf.cmd.Git.GPGHome,
+			Depth:          pf.cmd.Git.Depth,
+		}
+		if repo.AllowedSigners != "" {
+			options.AllowedSigners = repo.AllowedSigners
+		}
+		if repo.GPGHome != "" {
+			options.GPGHome = repo.GPGHome
+		}
+		if repo.Submodules != nil {
+			options.Submodules = *repo.Submodules
+		}
+		if repo.LFS != nil {
+			options.LFS = *repo.LFS
+		}
+
+		source, err := pf.source(repo.Source(), options)
 		if err != nil {
-			return fmt.Errorf("load repo %s: %w", repo, err)
+			return nil, fmt.Errorf("load repo %s: %w", repo.URL, err)
 		}
 
-		name := cmd.repoName(source)
+		name := pf.cmd.repoName(source)
+		if names[name] {
+			return nil, fmt.Errorf("repo %s: %w", name, errDuplicatedName)
+		}
+		names[name] = true
 
-		dir := filepath.Join(cmd.Output, name)
+		dir := filepath.Join(pf.cmd.Output, name)
 		repoEnv := &core.Environment{
-			Base:      env,
+			Base:      pf.base,
 			Name:      name,
 			Directory: dir,
-			Vars:      filterEnvironment(environ, name),
+			Vars:      mergeEnvironment(repo.Env, filterEnvironment(environ, name)),
+			Aliases:   repo.Domains,
 			Event:     event.Noop(),
 		}
 
-		ref := source.Ref()
-		logger.Info("repository detected", zap.String("repo", ref.Redacted()), zap.String("name", name), zap.String("workdir", dir))
+		if repo.Backup != "" || repo.BackupInterval > 0 {
+			repoEnv.Backup, err = pf.createStorage(repo)
+			if err != nil {
+				return nil, fmt.Errorf("initialize storage for repo %s: %w", name, err)
+			}
+		}
 
-		wg.Go(func() error {
-			defer cancel()
-			pipe.Run(ctx, source, repoEnv, cmd.Interval)
-			return nil
+		// any change in config or environment requires restart
+		revision, err := yaml.Marshal([]interface{}{repo, repoEnv.Vars})
+		if err != nil {
+			return nil, fmt.Errorf("serialize repo %s config: %w", name, err)
+		}
+
+		if pf.revisions[name] != string(revision) {
+			ref := source.Ref()
+			zap.L().Info("repository detected", zap.String("repo", ref.Redacted()), zap.String("name", name), zap.String("workdir", dir))
+		}
+
+		pipelines = append(pipelines, pipe.Pipeline{
+			Source:   source,
+			Env:      repoEnv,
+			Interval: repo.Interval.Or(pf.cmd.Interval),
+			Revision: string(revision),
 		})
 	}
 
-	return wg.Wait().ErrorOrNil()
+	if pf.policy != nil {
+		for i, repo := range repos {
+			name := pipelines[i].Env.Name
+			if repo.Auth != nil {
+				pf.policy.Set(name, pf.authPolicy(*repo.Auth))
+			} else {
+				pf.policy.Remove(name)
+			}
+		}
+		for name := range pf.revisions {
+			if !names[name] {
+				pf.policy.Remove(name)
+			}
+		}
+	}
+
+	pf.revisions = make(map[string]string, len(pipelines))
+	for _, p := range pipelines {
+		pf.revisions[p.Env.Name] = p.Revision
+	}
+	return pipelines, nil
 }
 
-var (
-	errUnknownProvider       = errors.New("unknown provider")
-	errUnknownBackupProtocol = errors.New("unknown backup protocol")
-)
+func (pf *pipelineFactory) createStorage(repo RepoConfig) (core.Storage, error) {
+	location := repo.Backup
+	if location == "" {
+		location = pf.cmd.Backup
+	}
+	provider, err := pf.cmd.createBackupProvider(location)
+	if err != nil {
+		return nil, err
+	}
+	return storage.New(provider, pf.docker, pf.encryption, "", "local", repo.BackupInterval.Or(pf.cmd.BackupInterval)), nil
+}
+
+func (pf *pipelineFactory) authPolicy(auth AuthConfig) embedded.RouteHandler {
+	switch {
+	case auth.Public:
+		return embedded.Public()
+	case auth.JWT != "":
+		return embedded.JWT(auth.JWT)
+	case pf.cmd.Router.JWT != "":
+		return embedded.JWT(pf.cmd.Router.JWT)
+	default:
+		return embedded.Public()
+	}
+}
 
-func (cmd CommandRun) createBackupProvider() (backup.Backup, error) {
-	if cmd.Backup == "" || cmd.Backup == "none" {
+// source for URL: prebuilt image (oci://), archive (by extension or archive+ prefix), local directory (dir:// or
+// file:// without git repo) or git repo. Only git repo can be verified by signatures.
+func (pf *pipelineFactory) source(rawURL string, options git.Options) (remote.Source, error) {
+	signed := options.AllowedSigners != "" || options.GPGHome != ""
+	nonGit := local.Supported(rawURL) || strings.HasPrefix(rawURL, oci.Scheme+"://") || archive.Supported(rawURL)
+	if signed && nonGit {
+		return nil, errSignaturesNotGit
+	}
+	switch {
+	case local.Supported(rawURL):
+		return local.New(rawURL)
+	case strings.HasPrefix(rawURL, oci.Scheme+"://"):
+		return oci.New(pf.docker, rawURL, options.Credentials)
+	case archive.Supported(rawURL):
+		return archive.New(rawURL, options.Credentials)
+	}
+	u, err := git.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if pf.cmd.Git.Driver == "native" {
+		if options.LFS {
+			return nil, errLFSNotSupported
+		}
+		if signed {
+			return nil, errSignaturesNotSupported
+		}
+		return gogit.NewWithOptions(*u, options), nil
+	}
+	return git.NewWithOptions(*u, options), nil
+}
+
+func (cmd CommandRun) createBackupProvider(location string) (backup.Backup, error) {
+	if location == "" || location == "none" {
 		return &nobackup.NoBackup{}, nil
 	}
-	u, err := url.Parse(cmd.Backup)
+	u, err := url.Parse(location)
 	if err != nil {
 		return nil, fmt.Errorf("parse url: %w", err)
 	}
@@ -247,6 +572,10 @@ func (cmd CommandRun) runRouter(ctx context.Context, router *embedded.Router) er
 
 func (cmd CommandRun) repoName(source remote.Source) string {
 	ref := source.Ref()
+	if scoped, ok := source.(remote.Scoped); ok && scoped.Subdir() != "" {
+		// several services from the same repo should have different names
+		ref.Path = strings.TrimSuffix(strings.TrimSuffix(ref.Path, "/"), ".git") + "/" + scoped.Subdir()
+	}
 	if cmd.FQDN {
 		return generateFullName(ref)
 	}
@@ -287,6 +616,17 @@ func (cmd CommandRun) logger() (*zap.Logger, error) {
 	}
 }
 
+// mergeEnvironment into one map. Next maps overwrites values from previous.
+func mergeEnvironment(environs ...map[string]string) map[string]string {
+	var res = map[string]string{}
+	for _, environ := range environs {
+		for key, value := range environ {
+			res[key] = value
+		}
+	}
+	return res
+}
+
 func filterEnvironment(environ map[string]string, repoName string) map[string]string {
 	appPrefix := strings.ReplaceAll(strings.ToUpper(repoName), "-", "_") + "_"
 
@@ -304,8 +644,7 @@ func filterEnvironment(environ map[string]string, repoName string) map[string]st
 func generateSimpleName(u url.URL) string {
 	names := strings.Split(u.Path, "/")
 	name := names[len(names)-1]
-	name = strings.TrimSuffix(name, ".git")
-	name = internal.ToDomain(name)
+	name = internal.ToDomain(trimExtension(name))
 	if name == "" {
 		return generateFullName(u)
 	}
@@ -313,7 +652,7 @@ func generateSimpleName(u url.URL) string {
 }
 
 func generateFullName(u url.URL) string {
-	baseName := u.Path
+	baseName := trimExtension(u.Path)
 
 	name := strings.ReplaceAll(baseName, "/", ".")
 	name = strings.Trim(name, ".git")
@@ -338,3 +677,13 @@ type logLevel zapcore.Level
 func (ll *logLevel) UnmarshalFlag(value string) error {
 	return (*zapcore.Level)(ll).UnmarshalText([]byte(value))
 }
+
+// trimExtension of repo (.git) or archive.
+func trimExtension(name string) string {
+	for _, ext := range []string{".git", ".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return name
+}
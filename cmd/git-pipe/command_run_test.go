@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/reddec/git-pipe/core/ingress"
+	"github.com/reddec/git-pipe/core/ingress/embedded"
+	"github.com/reddec/git-pipe/remote/git"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestPipelineFactory_source(t *testing.T) {
+	factory := &pipelineFactory{cmd: &CommandRun{}}
+	dir := t.TempDir()
+
+	for _, rawURL := range []string{
+		"dir://" + dir,
+		"file://" + dir,
+		"oci://registry.example.com/app:latest",
+		"https://example.com/site.tar.gz",
+	} {
+		_, err := factory.source(rawURL, git.Options{AllowedSigners: "allowed_signers"})
+		assert.ErrorIs(t, err, errSignaturesNotGit, rawURL)
+		_, err = factory.source(rawURL, git.Options{GPGHome: "/gpg"})
+		assert.ErrorIs(t, err, errSignaturesNotGit, rawURL)
+	}
+
+	source, err := factory.source("dir://"+dir, git.Options{})
+	require.NoError(t, err)
+	assert.NotNil(t, source)
+
+	source, err = factory.source("https://example.com/app.git", git.Options{AllowedSigners: "allowed_signers"})
+	require.NoError(t, err)
+	assert.NotNil(t, source)
+}
+
+func TestPipelineFactory_Build(t *testing.T) {
+	observed, logs := observer.New(zap.InfoLevel)
+	defer zap.ReplaceGlobals(zap.New(observed))()
+
+	root := t.TempDir()
+	alfa, beta := filepath.Join(root, "alfa"), filepath.Join(root, "beta")
+	require.NoError(t, os.Mkdir(alfa, 0700))
+	require.NoError(t, os.Mkdir(beta, 0700))
+
+	policy := embedded.NewPolicy(nil)
+	factory := &pipelineFactory{cmd: &CommandRun{Output: t.TempDir()}, policy: policy}
+
+	detected := func() []string {
+		var names []string
+		for _, entry := range logs.TakeAll() {
+			if entry.Message == "repository detected" {
+				names = append(names, entry.ContextMap()["name"].(string))
+			}
+		}
+		return names
+	}
+	authorized := func(group string) bool {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		return policy.ServeRoute(httptest.NewRecorder(), request, embedded.Route{Record: ingress.Record{Group: group}}) == nil
+	}
+
+	repos := []RepoConfig{
+		{URL: "dir://" + alfa, Auth: &AuthConfig{JWT: "secret"}},
+		{URL: "dir://" + beta},
+	}
+	pipelines, err := factory.Build(repos)
+	require.NoError(t, err)
+	assert.Len(t, pipelines, 2)
+	assert.ElementsMatch(t, []string{"alfa", "beta"}, detected())
+	assert.False(t, authorized("alfa"), "repo policy requires token")
+
+	t.Run("unchanged repos are not reported", func(t *testing.T) {
+		_, err := factory.Build(repos)
+		require.NoError(t, err)
+		assert.Empty(t, detected())
+	})
+
+	t.Run("policy of removed repo is removed", func(t *testing.T) {
+		_, err := factory.Build([]RepoConfig{{URL: "dir://" + beta, Env: map[string]string{"MODE": "dev"}}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"beta"}, detected(), "only changed repo is reported")
+		assert.True(t, authorized("alfa"))
+
+		_, err = factory.Build([]RepoConfig{{URL: "dir://" + alfa}, {URL: "dir://" + beta, Env: map[string]string{"MODE": "dev"}}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"alfa"}, detected())
+		assert.True(t, authorized("alfa"), "new repo with the same name should not inherit policy")
+	})
+}
+
+func TestPipelineFactory_Build_overrides(t *testing.T) {
+	root := t.TempDir()
+	envFile := filepath.Join(root, ".env")
+	require.NoError(t, ioutil.WriteFile(envFile, []byte("APP_DB_URL=postgres://env/app\nAPP_TOKEN=env-token\nSITE_MODE=debug\n"), 0600))
+	app, site := filepath.Join(root, "app"), filepath.Join(root, "site")
+	require.NoError(t, os.Mkdir(app, 0700))
+	require.NoError(t, os.Mkdir(site, 0700))
+
+	factory := &pipelineFactory{cmd: &CommandRun{Output: t.TempDir(), Interval: 30 * time.Second, EnvFile: []string{envFile}}}
+	pipelines, err := factory.Build([]RepoConfig{
+		{URL: "dir://" + app, Interval: duration(5 * time.Minute), Env: map[string]string{"DB_URL": "postgres://config/app", "MODE": "production"}},
+		{URL: "dir://" + site},
+	})
+	require.NoError(t, err)
+	require.Len(t, pipelines, 2)
+
+	overridden, defaults := pipelines[0], pipelines[1]
+	assert.Equal(t, 5*time.Minute, overridden.Interval)
+	assert.Equal(t, map[string]string{
+		"DB_URL": "postgres://env/app",
+		"TOKEN":  "env-token",
+		"MODE":   "production",
+	}, overridden.Env.Vars)
+	assert.Equal(t, 30*time.Second, defaults.Interval)
+	assert.Equal(t, map[string]string{"MODE": "debug"}, defaults.Env.Vars)
+
+	t.Run("missing password file", func(t *testing.T) {
+		_, err := factory.Build([]RepoConfig{{URL: "dir://" + app, Credentials: &Credentials{PasswordFile: filepath.Join(root, "missing")}}})
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
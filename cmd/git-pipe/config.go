@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/reddec/git-pipe/remote"
+	"github.com/reddec/git-pipe/remote/git"
+	"gopkg.in/yaml.v2"
+)
+
+// Config of deployment topology. Could be used instead of or together with positional arguments.
+type Config struct {
+	Repos []RepoConfig `yaml:"repos"`
+}
+
+// RepoConfig defines single repository and overrides for global settings.
+type RepoConfig struct {
+	URL            string            `yaml:"url"`             // remote git URL, required
+	Branch         string            `yaml:"branch"`          // branch name, overrides URL fragment
+	Tag            string            `yaml:"tag"`             // glob pattern for semantic version tags (ex: v1.*), overrides branch
+	Commit         string            `yaml:"commit"`          // exact commit, overrides tag and branch
+	Path           string            `yaml:"path"`            // subdirectory to deploy, overrides path from URL fragment
+	Interval       duration          `yaml:"interval"`        // poll interval
+	Backup         string            `yaml:"backup"`          // backup location
+	BackupInterval duration          `yaml:"backup_interval"` // backup interval
+	Env            map[string]string `yaml:"env"`             // extra environment variables (without prefix)
+	Domains        []string          `yaml:"domains"`         // domain aliases for the root service
+	Auth           *AuthConfig       `yaml:"auth"`            // authorization policy
+	Credentials    *Credentials      `yaml:"credentials"`     // credentials for private remote
+	Submodules     *bool             `yaml:"submodules"`      // fetch and update submodules, overrides global flag
+	LFS            *bool             `yaml:"lfs"`             // pull LFS objects, overrides global flag
+	AllowedSigners string            `yaml:"allowed_signers"` // allowed signers file for SSH signatures, overrides global flag
+	GPGHome        string            `yaml:"gpg_home"`        // keyring for GPG signatures, overrides global flag
+}
+
+// AuthConfig defines per-repo authorization policy, which overrides global one.
+type AuthConfig struct {
+	JWT    string `yaml:"jwt"`    // shared JWT key for the repo
+	Public bool   `yaml:"public"` // disable authorization for the repo
+}
+
+// Credentials for private remote. Overrides global git credentials. Username and password could be read from
+// environment variable or file.
+type Credentials struct {
+	SSHKey         string `yaml:"ssh_key"`          // path to SSH private key
+	SSHKeyPassword string `yaml:"ssh_key_password"` // passphrase for SSH private key (native driver only)
+	KnownHosts     string `yaml:"known_hosts"`      // path to known_hosts file
+	Username       string `yaml:"username"`
+	UsernameEnv    string `yaml:"username_env"`
+	UsernameFile   string `yaml:"username_file"`
+	Password       string `yaml:"password"` // password or token
+	PasswordEnv    string `yaml:"password_env"`
+	PasswordFile   string `yaml:"password_file"`
+}
+
+// Apply credentials over base (global) credentials.
+func (cr Credentials) Apply(base remote.Credentials) (remote.Credentials, error) {
+	username, err := secret(cr.Username, cr.UsernameEnv, cr.UsernameFile)
+	if err != nil {
+		return base, fmt.Errorf("read username: %w", err)
+	}
+	password, err := secret(cr.Password, cr.PasswordEnv, cr.PasswordFile)
+	if err != nil {
+		return base, fmt.Errorf("read password: %w", err)
+	}
+	for _, field := range []struct {
+		target *string
+		value  string
+	}{
+		{&base.SSHKey, cr.SSHKey},
+		{&base.SSHKeyPassword, cr.SSHKeyPassword},
+		{&base.KnownHosts, cr.KnownHosts},
+		{&base.Username, username},
+		{&base.Password, password},
+	} {
+		if field.value != "" {
+			*field.target = field.value
+		}
+	}
+	return base, nil
+}
+
+// secret value from plain text, environment variable or file (trailing new lines are trimmed).
+func secret(value, envName, file string) (string, error) {
+	switch {
+	case value != "":
+		return value, nil
+	case envName != "":
+		v, ok := os.LookupEnv(envName)
+		if !ok {
+			return "", fmt.Errorf("%s: %w", envName, errEnvNotSet)
+		}
+		return v, nil
+	case file != "":
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("read secret file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	default:
+		return "", nil
+	}
+}
+
+// Source URL with commit, tag or branch applied.
+func (rc RepoConfig) Source() string {
+	parts := strings.SplitN(rc.URL, "#", 2) //nolint:gomnd
+	if rc.Commit == "" && rc.Tag == "" && rc.Branch == "" && rc.Path == "" {
+		return rc.URL
+	}
+	target := git.ParseTarget("")
+	if len(parts) > 1 {
+		target = git.ParseTarget(parts[1])
+	}
+	switch {
+	case rc.Commit != "":
+		target = git.Target{Commit: rc.Commit, Path: target.Path}
+	case rc.Tag != "":
+		target = git.Target{Tag: rc.Tag, Path: target.Path}
+	case rc.Branch != "":
+		target = git.Target{Branch: rc.Branch, Path: target.Path}
+	}
+	if rc.Path != "" {
+		target.Path = rc.Path
+	}
+	return parts[0] + "#" + target.String()
+}
+
+// LoadConfig from YAML file.
+func LoadConfig(file string) (*Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse config file: %w", err)
+	}
+	for i, repo := range cfg.Repos {
+		if repo.URL == "" {
+			return nil, fmt.Errorf("repo #%d: %w", i+1, errRepoURLRequired)
+		}
+	}
+	return &cfg, nil
+}
+
+// duration in human-readable format (1h5m, 30s, ...).
+type duration time.Duration
+
+func (d *duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var text string
+	if err := unmarshal(&text); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(text)
+	if err != nil {
+		return fmt.Errorf("parse duration: %w", err)
+	}
+	*d = duration(v)
+	return nil
+}
+
+// Or returns duration or default value if duration not set.
+func (d duration) Or(defaultValue time.Duration) time.Duration {
+	if d <= 0 {
+		return defaultValue
+	}
+	return time.Duration(d)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/reddec/git-pipe/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentials_Apply(t *testing.T) {
+	base := remote.Credentials{SSHKey: "/keys/global", KnownHosts: "/keys/known_hosts", Username: "global", Password: "global-token"}
+
+	t.Run("empty keeps base", func(t *testing.T) {
+		credentials, err := Credentials{}.Apply(base)
+		require.NoError(t, err)
+		assert.Equal(t, base, credentials)
+	})
+
+	t.Run("fields override base", func(t *testing.T) {
+		credentials, err := Credentials{SSHKey: "/keys/team", Username: "deploy", Password: "token"}.Apply(base)
+		require.NoError(t, err)
+		assert.Equal(t, remote.Credentials{SSHKey: "/keys/team", KnownHosts: "/keys/known_hosts", Username: "deploy", Password: "token"}, credentials)
+	})
+
+	t.Run("secrets from env and file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "token")
+		require.NoError(t, ioutil.WriteFile(file, []byte("file-token\r\n"), 0600))
+		require.NoError(t, os.Setenv("TEST_GIT_PIPE_USERNAME", "env-user"))
+		defer os.Unsetenv("TEST_GIT_PIPE_USERNAME")
+
+		credentials, err := Credentials{UsernameEnv: "TEST_GIT_PIPE_USERNAME", PasswordFile: file}.Apply(base)
+		require.NoError(t, err)
+		assert.Equal(t, "env-user", credentials.Username)
+		assert.Equal(t, "file-token", credentials.Password)
+	})
+
+	t.Run("plain value has priority", func(t *testing.T) {
+		credentials, err := Credentials{Password: "plain", PasswordEnv: "TEST_GIT_PIPE_UNSET", PasswordFile: "/not/exists"}.Apply(base)
+		require.NoError(t, err)
+		assert.Equal(t, "plain", credentials.Password)
+	})
+
+	t.Run("missing env", func(t *testing.T) {
+		_, err := Credentials{PasswordEnv: "TEST_GIT_PIPE_UNSET"}.Apply(base)
+		assert.ErrorIs(t, err, errEnvNotSet)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := Credentials{UsernameFile: filepath.Join(t.TempDir(), "missing")}.Apply(base)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("file-token\n"), 0600))
+
+	file := writeConfig(t, dir, `
+repos:
+  - url: https://example.com/app.git
+  - url: https://example.com/api.git
+    branch: main
+    interval: 5m
+    backup_interval: 6h
+    env:
+      DB_URL: postgres://db/api
+    submodules: false
+    auth:
+      jwt: api-secret
+    credentials:
+      username: deploy
+      password_file: `+tokenFile+`
+`)
+	cfg, err := LoadConfig(file)
+	require.NoError(t, err)
+	require.Len(t, cfg.Repos, 2)
+
+	defaults := cfg.Repos[0]
+	assert.Equal(t, RepoConfig{URL: "https://example.com/app.git"}, defaults)
+	assert.Equal(t, 30*time.Second, defaults.Interval.Or(30*time.Second))
+	assert.Equal(t, "https://example.com/app.git", defaults.Source())
+
+	overrides := cfg.Repos[1]
+	assert.Equal(t, 5*time.Minute, overrides.Interval.Or(30*time.Second))
+	assert.Equal(t, 6*time.Hour, overrides.BackupInterval.Or(time.Hour))
+	assert.Equal(t, map[string]string{"DB_URL": "postgres://db/api"}, overrides.Env)
+	require.NotNil(t, overrides.Submodules)
+	assert.False(t, *overrides.Submodules)
+	assert.Nil(t, overrides.LFS)
+	assert.Equal(t, &AuthConfig{JWT: "api-secret"}, overrides.Auth)
+	assert.Equal(t, "https://example.com/api.git#main", overrides.Source())
+
+	require.NotNil(t, overrides.Credentials)
+	assert.Equal(t, tokenFile, overrides.Credentials.PasswordFile)
+	credentials, err := overrides.Credentials.Apply(remote.Credentials{Username: "global", Password: "global-token"})
+	require.NoError(t, err)
+	assert.Equal(t, remote.Credentials{Username: "deploy", Password: "file-token"}, credentials)
+
+	t.Run("url required", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, t.TempDir(), "repos:\n  - branch: main\n"))
+		assert.ErrorIs(t, err, errRepoURLRequired)
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, t.TempDir(), "repos:\n  - url: https://example.com/app.git\n    brunch: main\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid duration", func(t *testing.T) {
+		_, err := LoadConfig(writeConfig(t, t.TempDir(), "repos:\n  - url: https://example.com/app.git\n    interval: often\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestRepoConfig_Source(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config RepoConfig
+		source string
+	}{
+		{name: "url as is", config: RepoConfig{URL: "https://example.com/app.git#dev:web"}, source: "https://example.com/app.git#dev:web"},
+		{name: "branch", config: RepoConfig{URL: "https://example.com/app.git", Branch: "main"}, source: "https://example.com/app.git#main"},
+		{name: "branch overrides fragment and keeps path", config: RepoConfig{URL: "https://example.com/app.git#dev:web", Branch: "main"}, source: "https://example.com/app.git#main:web"},
+		{name: "tag overrides branch", config: RepoConfig{URL: "https://example.com/app.git", Branch: "main", Tag: "v1.*"}, source: "https://example.com/app.git#tag:v1.*"},
+		{name: "commit overrides tag", config: RepoConfig{URL: "https://example.com/app.git", Tag: "v1.*", Commit: "abc123"}, source: "https://example.com/app.git#commit:abc123"},
+		{name: "path overrides fragment", config: RepoConfig{URL: "https://example.com/app.git#dev:web", Path: "api"}, source: "https://example.com/app.git#dev:api"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.source, tc.config.Source())
+		})
+	}
+}
+
+func TestCommandRun_repos(t *testing.T) {
+	file := writeConfig(t, t.TempDir(), "repos:\n  - url: https://example.com/app.git\n    branch: main\n")
+
+	cmd := CommandRun{Config: file}
+	cmd.Args.Repos = []string{"https://example.com/site.git"}
+	repos, err := cmd.repos()
+	require.NoError(t, err)
+	assert.Equal(t, []RepoConfig{
+		{URL: "https://example.com/app.git", Branch: "main"},
+		{URL: "https://example.com/site.git"},
+	}, repos)
+
+	_, err = CommandRun{}.repos()
+	assert.ErrorIs(t, err, errNoRepos)
+}
+
+func TestEnvironment(t *testing.T) {
+	environ := map[string]string{
+		"APP_TOKEN":      "env-token",
+		"APP_DB_URL":     "postgres://env/app",
+		"MY_APP_MODE":    "debug",
+		"OTHER_TOKEN":    "other",
+		"UNRELATED":      "value",
+		"APPLICATION_ID": "42",
+	}
+
+	assert.Equal(t, map[string]string{"TOKEN": "env-token", "DB_URL": "postgres://env/app"}, filterEnvironment(environ, "app"))
+	assert.Equal(t, map[string]string{"MODE": "debug"}, filterEnvironment(environ, "my-app"))
+
+	vars := mergeEnvironment(map[string]string{"DB_URL": "postgres://config/app", "MODE": "production"}, filterEnvironment(environ, "app"))
+	assert.Equal(t, map[string]string{
+		"TOKEN":  "env-token",
+		"DB_URL": "postgres://env/app",
+		"MODE":   "production",
+	}, vars, "environment has priority over config")
+}
+
+func writeConfig(t *testing.T, dir, content string) string {
+	file := filepath.Join(dir, "git-pipe.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	return file
+}
@@ -16,8 +16,12 @@ var global context.Context // global context for main package only!
 
 func main() {
 	var app struct {
-		Run CommandRun `command:"run" description:"(default) run git-pipe and serve repos"`
-		JWT CommandJWT `command:"jwt" description:"helper to generate JWT"`
+		Run      CommandRun      `command:"run" description:"(default) run git-pipe and serve repos"`
+		JWT      CommandJWT      `command:"jwt" description:"helper to generate JWT"`
+		Status   CommandStatus   `command:"status" description:"show status of repos in running instance"`
+		Redeploy CommandRedeploy `command:"redeploy" description:"force redeploy repo in running instance"`
+		Backup   CommandBackup   `command:"backup" description:"backup repo volumes in running instance"`
+		Restore  CommandRestore  `command:"restore" description:"restore repo volumes from backup in running instance"`
 	}
 
 	parser := flags.NewParser(&app, flags.Default)
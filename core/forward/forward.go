@@ -0,0 +1,323 @@
+// Package forward exposes non-HTTP (TCP and UDP) ports of containers on host. Each host port is owned by a single
+// group. Traffic is balanced between addresses at random: per connection for TCP and per client for UDP.
+//
+// Allocation rules:
+// - only ports from allowed range could be used, empty range disables forwarding;
+// - requested (published) port outside of the range is skipped;
+// - port 0 means allocate: the same port as for the previous version of the group is used if possible, otherwise
+// the first free port from the end of the range (to reduce conflicts with requested ports);
+// - requested port which is already forwarded for another group is a conflict.
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/reddec/git-pipe/core"
+	"github.com/reddec/git-pipe/internal"
+	"go.uber.org/zap"
+)
+
+// Supported protocols.
+const (
+	TCP = "tcp"
+	UDP = "udp"
+)
+
+var (
+	ErrInvalidRange     = errors.New("invalid port range")
+	ErrUnknownProtocol  = errors.New("unknown protocol")
+	ErrDuplicatedPort   = errors.New("port requested twice")
+	ErrNoFreePort       = errors.New("no free port in range")
+	ErrNoAddresses      = errors.New("no addresses")
+	errNotAllowedByRule = errors.New("port is out of allowed range")
+)
+
+// Resolver of container address to routable endpoint.
+type Resolver interface {
+	// Resolve address or address with port to routable (from application) endpoint (with port if needed).
+	Resolve(ctx context.Context, address string) (string, error)
+}
+
+// Range of allowed host ports (inclusive). Zero value means no ports allowed.
+type Range struct {
+	From int
+	To   int
+}
+
+// ParseRange parses range as from-to or as single port. Empty string means empty range.
+func ParseRange(text string) (Range, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return Range{}, nil
+	}
+	parts := strings.SplitN(text, "-", 2) //nolint:gomnd
+	from, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return Range{}, fmt.Errorf("%w: %q", ErrInvalidRange, text)
+	}
+	to := from
+	if len(parts) > 1 {
+		to, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return Range{}, fmt.Errorf("%w: %q", ErrInvalidRange, text)
+		}
+	}
+	if from <= 0 || to > 65535 || from > to {
+		return Range{}, fmt.Errorf("%w: %q", ErrInvalidRange, text)
+	}
+	return Range{From: from, To: to}, nil
+}
+
+// Contains port.
+func (r Range) Contains(port int) bool {
+	return r.From > 0 && port >= r.From && port <= r.To
+}
+
+func (r Range) String() string {
+	return strconv.Itoa(r.From) + "-" + strconv.Itoa(r.To)
+}
+
+// ErrPortUsed returned in case port already forwarded for another group.
+type ErrPortUsed struct {
+	Port        int
+	Protocol    string
+	LeaserGroup string
+}
+
+func (epu *ErrPortUsed) Error() string {
+	return "port " + strconv.Itoa(epu.Port) + "/" + epu.Protocol + " already used by group " + epu.LeaserGroup
+}
+
+// AsErrPortUsed tries convert arbitrary error as corresponded error type.
+// Returns true only in case conversion successful.
+func AsErrPortUsed(err error) (*ErrPortUsed, bool) {
+	var epu *ErrPortUsed
+	return epu, errors.As(err, &epu)
+}
+
+// New forwarder which listens ports from range on host interface. Nil resolver disables address resolution.
+func New(host string, ports Range, resolver Resolver) *Forwarder {
+	return &Forwarder{
+		host:      host,
+		ports:     ports,
+		resolver:  resolver,
+		listeners: make(map[endpoint]*listener),
+		allocated: make(map[string]endpoint),
+	}
+}
+
+// Forwarder of host ports. Implements core.Forwarder.
+type Forwarder struct {
+	host      string
+	ports     Range
+	resolver  Resolver
+	lock      sync.Mutex
+	listeners map[endpoint]*listener
+	allocated map[string]endpoint // group and forward name -> allocated port, kept between versions
+}
+
+// Clear forwarded ports of the group. Active TCP connections are not interrupted.
+func (fw *Forwarder) Clear(ctx context.Context, group string) error {
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+	for ep, ln := range fw.listeners {
+		if ln.group == group {
+			fw.closeListener(ctx, ep, ln)
+		}
+	}
+	return nil
+}
+
+// Set (replaces) forwarded ports of the group. Listeners for ports used by the previous version are kept, so traffic is
+// switched without interruption. State is not changed in case of error.
+func (fw *Forwarder) Set(ctx context.Context, group string, forwards []core.Forward) (map[string]int, error) {
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+	logger := internal.SubLogger(ctx, "forward")
+
+	wanted := make(map[endpoint]core.Forward, len(forwards))
+	var dynamic []core.Forward
+	for _, forward := range forwards {
+		if forward.Protocol == "" {
+			forward.Protocol = TCP
+		}
+		if forward.Protocol != TCP && forward.Protocol != UDP {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownProtocol, forward.Protocol)
+		}
+		if len(forward.Addresses) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNoAddresses, forward.Name)
+		}
+		if forward.Port == 0 {
+			dynamic = append(dynamic, forward)
+			continue
+		}
+		if !fw.ports.Contains(forward.Port) {
+			logger.Debug("port not forwarded", zap.String("name", forward.Name), zap.Int("port", forward.Port), zap.Error(errNotAllowedByRule))
+			continue
+		}
+		ep := endpoint{protocol: forward.Protocol, port: forward.Port}
+		if err := fw.checkFree(group, ep, wanted); err != nil {
+			return nil, err
+		}
+		wanted[ep] = forward
+	}
+
+	for _, forward := range dynamic {
+		if fw.ports.From == 0 {
+			logger.Debug("port not allocated", zap.String("name", forward.Name), zap.Error(errNotAllowedByRule))
+			continue
+		}
+		ep, err := fw.allocate(group, forward, wanted)
+		if err != nil {
+			return nil, fmt.Errorf("allocate port for %s: %w", forward.Name, err)
+		}
+		wanted[ep] = forward
+	}
+
+	// open new listeners first, so nothing changed in case of error
+	opened := make(map[endpoint]*listener)
+	for ep := range wanted {
+		if _, exists := fw.listeners[ep]; exists {
+			continue
+		}
+		ln, err := fw.listen(ctx, group, ep)
+		if err != nil {
+			for oep, oln := range opened {
+				fw.closeListener(ctx, oep, oln)
+			}
+			return nil, fmt.Errorf("listen %s: %w", ep, err)
+		}
+		opened[ep] = ln
+		fw.listeners[ep] = ln
+	}
+
+	// close ports which are not used by the new version
+	for ep, ln := range fw.listeners {
+		if _, keep := wanted[ep]; !keep && ln.group == group {
+			fw.closeListener(ctx, ep, ln)
+		}
+	}
+
+	var ports = make(map[string]int, len(wanted))
+	for ep, forward := range wanted {
+		fw.listeners[ep].setAddresses(forward.Addresses)
+		fw.allocated[allocationKey(group, forward.Name)] = ep
+		ports[forward.Name] = ep.port
+		logger.Info("forwarding port", zap.String("group", group), zap.String("name", forward.Name), zap.String("protocol", ep.protocol), zap.Int("port", ep.port), zap.Strings("addresses", forward.Addresses))
+	}
+	return ports, nil
+}
+
+func (fw *Forwarder) checkFree(group string, ep endpoint, wanted map[endpoint]core.Forward) error {
+	if _, duplicated := wanted[ep]; duplicated {
+		return fmt.Errorf("%w: %s", ErrDuplicatedPort, ep)
+	}
+	if ln, exists := fw.listeners[ep]; exists && ln.group != group {
+		return &ErrPortUsed{Port: ep.port, Protocol: ep.protocol, LeaserGroup: ln.group}
+	}
+	return nil
+}
+
+func (fw *Forwarder) allocate(group string, forward core.Forward, wanted map[endpoint]core.Forward) (endpoint, error) {
+	if ep, ok := fw.allocated[allocationKey(group, forward.Name)]; ok && ep.protocol == forward.Protocol && fw.ports.Contains(ep.port) {
+		if fw.checkFree(group, ep, wanted) == nil {
+			return ep, nil
+		}
+	}
+	for port := fw.ports.To; port >= fw.ports.From; port-- {
+		ep := endpoint{protocol: forward.Protocol, port: port}
+		if fw.checkFree(group, ep, wanted) != nil {
+			continue
+		}
+		if ln, exists := fw.listeners[ep]; exists && ln.group == group {
+			continue // used by the previous version for another forward
+		}
+		return ep, nil
+	}
+	return endpoint{}, ErrNoFreePort
+}
+
+func (fw *Forwarder) listen(ctx context.Context, group string, ep endpoint) (*listener, error) {
+	address := net.JoinHostPort(fw.host, strconv.Itoa(ep.port))
+	logger := internal.LoggerFromContext(ctx).With(zap.String("group", group), zap.String("listen", address))
+	// listener outlives request context
+	serveCtx := internal.WithLogger(context.Background(), logger)
+	ln := &listener{group: group}
+
+	switch ep.protocol {
+	case TCP:
+		socket, err := net.Listen(TCP, address)
+		if err != nil {
+			return nil, err
+		}
+		ln.closer = socket
+		go serveTCP(serveCtx, socket, ln, fw.resolver)
+	case UDP:
+		socket, err := net.ListenPacket(UDP, address)
+		if err != nil {
+			return nil, err
+		}
+		ln.closer = socket
+		go serveUDP(serveCtx, socket, ln, fw.resolver)
+	}
+	return ln, nil
+}
+
+func (fw *Forwarder) closeListener(ctx context.Context, ep endpoint, ln *listener) {
+	delete(fw.listeners, ep)
+	if err := ln.closer.Close(); err != nil {
+		internal.LoggerFromContext(ctx).Warn("close listener", zap.String("group", ln.group), zap.Stringer("port", ep), zap.Error(err))
+	}
+}
+
+type endpoint struct {
+	protocol string
+	port     int
+}
+
+func (ep endpoint) String() string {
+	return strconv.Itoa(ep.port) + "/" + ep.protocol
+}
+
+func allocationKey(group, name string) string {
+	return group + "/" + name
+}
+
+type listener struct {
+	group     string
+	closer    interface{ Close() error }
+	lock      sync.RWMutex
+	addresses []string
+}
+
+func (ln *listener) setAddresses(addresses []string) {
+	ln.lock.Lock()
+	defer ln.lock.Unlock()
+	ln.addresses = addresses
+}
+
+// target picks random address and resolves it.
+func (ln *listener) target(ctx context.Context, resolver Resolver) (string, error) {
+	ln.lock.RLock()
+	if len(ln.addresses) == 0 {
+		ln.lock.RUnlock()
+		return "", ErrNoAddresses
+	}
+	address := ln.addresses[rand.Int()%len(ln.addresses)] //nolint:gosec
+	ln.lock.RUnlock()
+	if resolver == nil {
+		return address, nil
+	}
+	endpoint, err := resolver.Resolve(ctx, address)
+	if err != nil {
+		return "", fmt.Errorf("resolve address %s: %w", address, err)
+	}
+	return endpoint, nil
+}
@@ -0,0 +1,193 @@
+package forward_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/reddec/git-pipe/core"
+	"github.com/reddec/git-pipe/core/forward"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	r, err := forward.ParseRange("5000-5010")
+	require.NoError(t, err)
+	assert.Equal(t, forward.Range{From: 5000, To: 5010}, r)
+	assert.True(t, r.Contains(5000))
+	assert.True(t, r.Contains(5010))
+	assert.False(t, r.Contains(5011))
+
+	r, err = forward.ParseRange("5432")
+	require.NoError(t, err)
+	assert.Equal(t, forward.Range{From: 5432, To: 5432}, r)
+
+	r, err = forward.ParseRange("")
+	require.NoError(t, err)
+	assert.False(t, r.Contains(80))
+
+	for _, text := range []string{"abc", "10-5", "0-10", "1-70000"} {
+		_, err = forward.ParseRange(text)
+		assert.ErrorIs(t, err, forward.ErrInvalidRange, text)
+	}
+}
+
+func TestForwarder_TCP(t *testing.T) {
+	upstream := tcpEcho(t)
+	port := freeTCPPort(t)
+	fw := forward.New("127.0.0.1", forward.Range{From: port, To: port}, nil)
+	ctx := context.Background()
+
+	ports, err := fw.Set(ctx, "db", []core.Forward{{Name: "db:5432/tcp", Port: port, Addresses: []string{upstream}}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"db:5432/tcp": port}, ports)
+	defer fw.Clear(ctx, "db") //nolint:errcheck
+
+	conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", line)
+}
+
+func TestForwarder_UDP(t *testing.T) {
+	upstream := udpEcho(t)
+	port := freeUDPPort(t)
+	fw := forward.New("127.0.0.1", forward.Range{From: port, To: port}, nil)
+	ctx := context.Background()
+
+	ports, err := fw.Set(ctx, "game", []core.Forward{{Name: "game:27015/udp", Protocol: "udp", Addresses: []string{upstream}}})
+	require.NoError(t, err)
+	defer fw.Clear(ctx, "game") //nolint:errcheck
+	assert.Equal(t, port, ports["game:27015/udp"])
+
+	conn, err := net.Dial("udp", "127.0.0.1:"+strconv.Itoa(port))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buffer := make([]byte, 16)
+	n, err := conn.Read(buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buffer[:n]))
+}
+
+func TestForwarder_Rules(t *testing.T) {
+	port := freeUDPPort(t)
+	fw := forward.New("127.0.0.1", forward.Range{From: port, To: port}, nil)
+	ctx := context.Background()
+	addresses := []string{"127.0.0.1:1"}
+
+	t.Run("out of range port is skipped", func(t *testing.T) {
+		ports, err := fw.Set(ctx, "web", []core.Forward{{Name: "web:80/tcp", Port: port - 1, Addresses: addresses}})
+		require.NoError(t, err)
+		assert.Empty(t, ports)
+	})
+
+	t.Run("allocated port is kept for the next version", func(t *testing.T) {
+		ports, err := fw.Set(ctx, "app", []core.Forward{{Name: "app:53/udp", Protocol: "udp", Addresses: addresses}})
+		require.NoError(t, err)
+		assert.Equal(t, port, ports["app:53/udp"])
+
+		ports, err = fw.Set(ctx, "app", []core.Forward{{Name: "app:53/udp", Protocol: "udp", Addresses: addresses}})
+		require.NoError(t, err)
+		assert.Equal(t, port, ports["app:53/udp"])
+	})
+
+	t.Run("used port is conflict", func(t *testing.T) {
+		_, err := fw.Set(ctx, "other", []core.Forward{{Name: "other:53/udp", Protocol: "udp", Port: port, Addresses: addresses}})
+		used, ok := forward.AsErrPortUsed(err)
+		require.True(t, ok)
+		assert.Equal(t, "app", used.LeaserGroup)
+
+		_, err = fw.Set(ctx, "other", []core.Forward{{Name: "other:53/udp", Protocol: "udp", Addresses: addresses}})
+		assert.ErrorIs(t, err, forward.ErrNoFreePort)
+	})
+
+	t.Run("duplicated port", func(t *testing.T) {
+		_, err := fw.Set(ctx, "app", []core.Forward{
+			{Name: "a", Port: port, Addresses: addresses},
+			{Name: "b", Port: port, Addresses: addresses},
+		})
+		assert.ErrorIs(t, err, forward.ErrDuplicatedPort)
+	})
+
+	t.Run("port released after clear", func(t *testing.T) {
+		require.NoError(t, fw.Clear(ctx, "app"))
+		ports, err := fw.Set(ctx, "other", []core.Forward{{Name: "other:53/udp", Protocol: "udp", Port: port, Addresses: addresses}})
+		require.NoError(t, err)
+		assert.Equal(t, port, ports["other:53/udp"])
+		require.NoError(t, fw.Clear(ctx, "other"))
+	})
+}
+
+func TestForwarder_Disabled(t *testing.T) {
+	fw := forward.New("127.0.0.1", forward.Range{}, nil)
+	ports, err := fw.Set(context.Background(), "app", []core.Forward{
+		{Name: "app:5432/tcp", Port: 5432, Addresses: []string{"127.0.0.1:5432"}},
+		{Name: "app:53/udp", Protocol: "udp", Addresses: []string{"127.0.0.1:53"}},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, ports)
+}
+
+func freeTCPPort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	require.NoError(t, ln.Close())
+	return port
+}
+
+func freeUDPPort(t *testing.T) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	require.NoError(t, conn.Close())
+	return port
+}
+
+func tcpEcho(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				_, _ = conn.Write([]byte(line))
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func udpEcho(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(buffer[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
@@ -0,0 +1,64 @@
+package forward
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/reddec/git-pipe/internal"
+	"go.uber.org/zap"
+)
+
+// serveTCP accepts connections till listener closed. Each connection is proxied to random address.
+func serveTCP(ctx context.Context, socket net.Listener, ln *listener, resolver Resolver) {
+	logger := internal.LoggerFromContext(ctx)
+	for {
+		conn, err := socket.Accept()
+		if err != nil {
+			logger.Debug("tcp listener stopped", zap.Error(err))
+			return
+		}
+		go proxyTCP(ctx, conn, ln, resolver)
+	}
+}
+
+func proxyTCP(ctx context.Context, conn net.Conn, ln *listener, resolver Resolver) {
+	defer conn.Close()
+	logger := internal.LoggerFromContext(ctx).With(zap.Stringer("client", conn.RemoteAddr()))
+
+	target, err := ln.target(ctx, resolver)
+	if err != nil {
+		logger.Warn("failed to get target", zap.Error(err))
+		return
+	}
+	upstream, err := (&net.Dialer{}).DialContext(ctx, TCP, target)
+	if err != nil {
+		logger.Warn("failed to connect", zap.String("target", target), zap.Error(err))
+		return
+	}
+	defer upstream.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2) //nolint:gomnd
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(upstream, conn)
+		closeWrite(upstream)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(conn, upstream)
+		closeWrite(conn)
+	}()
+	wg.Wait()
+}
+
+// closeWrite half-closes connection if possible, so the other side gets EOF.
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+		return
+	}
+	_ = conn.Close()
+}
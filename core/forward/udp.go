@@ -0,0 +1,113 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/reddec/git-pipe/internal"
+	"go.uber.org/zap"
+)
+
+const (
+	udpIdleTimeout = time.Minute
+	udpBufferSize  = 65535
+)
+
+// serveUDP reads datagrams till socket closed. Each client gets own session with random address, which is closed
+// after idle timeout.
+func serveUDP(ctx context.Context, socket net.PacketConn, ln *listener, resolver Resolver) {
+	logger := internal.LoggerFromContext(ctx)
+	var (
+		lock     sync.Mutex
+		sessions = make(map[string]*udpSession)
+	)
+	defer func() {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, session := range sessions {
+			_ = session.upstream.Close()
+		}
+	}()
+
+	buffer := make([]byte, udpBufferSize)
+	for {
+		n, client, err := socket.ReadFrom(buffer)
+		if err != nil {
+			logger.Debug("udp listener stopped", zap.Error(err))
+			return
+		}
+		key := client.String()
+
+		lock.Lock()
+		session, ok := sessions[key]
+		if !ok {
+			session, err = newUDPSession(ctx, ln, resolver)
+			if err != nil {
+				lock.Unlock()
+				logger.Warn("failed to create session", zap.String("client", key), zap.Error(err))
+				continue
+			}
+			sessions[key] = session
+			go func() {
+				session.reply(socket, client)
+				lock.Lock()
+				delete(sessions, key)
+				lock.Unlock()
+			}()
+		}
+		lock.Unlock()
+
+		session.touch()
+		if _, err := session.upstream.Write(buffer[:n]); err != nil {
+			logger.Debug("failed to send datagram", zap.String("client", key), zap.Error(err))
+		}
+	}
+}
+
+type udpSession struct {
+	upstream net.Conn
+	lastSeen int64 // unix nano of the last datagram from client
+}
+
+func newUDPSession(ctx context.Context, ln *listener, resolver Resolver) (*udpSession, error) {
+	target, err := ln.target(ctx, resolver)
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := (&net.Dialer{}).DialContext(ctx, UDP, target)
+	if err != nil {
+		return nil, err
+	}
+	return &udpSession{upstream: upstream, lastSeen: time.Now().UnixNano()}, nil
+}
+
+func (us *udpSession) touch() {
+	atomic.StoreInt64(&us.lastSeen, time.Now().UnixNano())
+}
+
+// reply copies datagrams from upstream to client till session is idle or closed.
+func (us *udpSession) reply(socket net.PacketConn, client net.Addr) {
+	defer us.upstream.Close()
+	buffer := make([]byte, udpBufferSize)
+	for {
+		_ = us.upstream.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+		n, err := us.upstream.Read(buffer)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if time.Since(time.Unix(0, atomic.LoadInt64(&us.lastSeen))) < udpIdleTimeout {
+				continue
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+		if _, err := socket.WriteTo(buffer[:n], client); err != nil {
+			return
+		}
+	}
+}
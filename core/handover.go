@@ -0,0 +1,101 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// NewHandover between generations of the same package. Nil previous means that there is no previous generation.
+func NewHandover(previous *Handover, stopPrevious func()) *Handover {
+	return &Handover{previous: previous, stopPrevious: stopPrevious}
+}
+
+// Handover between generations of the same package during redeploy: the next generation starts
+// while the previous one is still serving traffic, and the previous one stops only after the next one is ready
+// or when the next one explicitly asks for it.
+// Nil value is valid and means that there is no previous generation and package will never be replaced.
+type Handover struct {
+	previous     *Handover
+	stopPrevious func()
+	once         sync.Once
+	replaced     int32
+	finished     int32
+	lock         sync.Mutex
+	routes       *Routes
+}
+
+// Routes published by generation of package.
+type Routes struct {
+	Domains  map[string][]string // ingress records
+	Forwards []Forward           // forwarded ports
+}
+
+// Takeover returns true if previous generation was running when the package started, so shared resources
+// (volumes, routes) are already initialized and should not be re-initialized.
+func (h *Handover) Takeover() bool {
+	return h != nil && h.stopPrevious != nil
+}
+
+// StopPrevious generation and wait for it. Should be used by packages which can not run side by side with
+// the previous version. Safe to call multiple times.
+func (h *Handover) StopPrevious() {
+	if h == nil || h.stopPrevious == nil {
+		return
+	}
+	h.once.Do(h.stopPrevious)
+}
+
+// Replace marks package as replaced by the next generation.
+func (h *Handover) Replace() {
+	if h != nil {
+		atomic.StoreInt32(&h.replaced, 1)
+	}
+}
+
+// Replaced returns true if the next generation took over shared resources (routes), so they should not be cleared
+// during shutdown.
+func (h *Handover) Replaced() bool {
+	return h != nil && atomic.LoadInt32(&h.replaced) == 1
+}
+
+// Publish remembers routes of the generation, so they could be restored if the next generation fails after
+// taking them over.
+func (h *Handover) Publish(routes Routes) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.routes = &routes
+}
+
+// Finish marks generation as stopped. Routes of finished generation will not be restored.
+func (h *Handover) Finish() {
+	if h == nil {
+		return
+	}
+	atomic.StoreInt32(&h.finished, 1)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.previous = nil // do not keep chain of all generations
+}
+
+// Previous returns published routes of the previous generation if it is still running. Failed generation should
+// restore them instead of clearing.
+func (h *Handover) Previous() (Routes, bool) {
+	if h == nil {
+		return Routes{}, false
+	}
+	h.lock.Lock()
+	prev := h.previous
+	h.lock.Unlock()
+	if prev == nil || atomic.LoadInt32(&prev.finished) == 1 {
+		return Routes{}, false
+	}
+	prev.lock.Lock()
+	defer prev.lock.Unlock()
+	if prev.routes == nil {
+		return Routes{}, false
+	}
+	return *prev.routes, true
+}
@@ -0,0 +1,34 @@
+package core_test
+
+import (
+	"testing"
+
+	"github.com/reddec/git-pipe/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandover_Previous(t *testing.T) {
+	routes := core.Routes{Domains: map[string][]string{"app": {"10.0.0.1:80"}}}
+
+	var empty *core.Handover
+	_, ok := empty.Previous()
+	assert.False(t, ok, "nil handover has no previous")
+
+	previous := core.NewHandover(nil, nil)
+	next := core.NewHandover(previous, func() {})
+	_, ok = next.Previous()
+	assert.False(t, ok, "previous did not publish routes")
+
+	previous.Publish(routes)
+	restored, ok := next.Previous()
+	assert.True(t, ok)
+	assert.Equal(t, routes, restored)
+
+	previous.Finish()
+	_, ok = next.Previous()
+	assert.False(t, ok, "routes of finished previous should not be restored")
+
+	next.Finish()
+	_, ok = next.Previous()
+	assert.False(t, ok)
+}
@@ -10,9 +10,12 @@ import (
 	"go.uber.org/zap"
 )
 
+// FileScheme is prefix of address which points to local directory with static files instead of network service.
+const FileScheme = "file://"
+
 type Record struct {
 	Domain    string   // unique reference to service.
-	Addresses []string // host:port, could be multiple in case of scale factor > 1
+	Addresses []string // host:port or FileScheme + directory, could be multiple in case of scale factor > 1
 	Group     string   // namespace, not used in routing
 }
 
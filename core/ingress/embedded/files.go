@@ -0,0 +1,74 @@
+package embedded
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/reddec/git-pipe/core/ingress"
+)
+
+const indexFile = "index.html"
+
+// Files serves static files for records with local directory address (see ingress.FileScheme).
+// Requests to other records are passed to the next handler. Hidden files (starting from dot) are not served,
+// except .well-known. Links pointing outside the directory are not followed and directories are not listed.
+func Files() RouteHandler {
+	return RouteHandlerFunc(func(writer http.ResponseWriter, request *http.Request, record Route) error {
+		if len(record.Record.Addresses) == 0 || !strings.HasPrefix(record.Record.Addresses[0], ingress.FileScheme) {
+			return nil
+		}
+		if hidden(request.URL.Path) {
+			http.NotFound(writer, request)
+			return ErrAbort
+		}
+		dir := strings.TrimPrefix(record.Record.Addresses[0], ingress.FileScheme)
+		http.FileServer(siteDir(dir)).ServeHTTP(writer, request)
+		return ErrAbort
+	})
+}
+
+func hidden(path string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, ".") && segment != ".well-known" {
+			return true
+		}
+	}
+	return false
+}
+
+// siteDir is file system which serves only content inside the directory. Files are resolved with links, so links
+// to host files (ex: /etc/passwd) are not served. Directories without index file are hidden to disable listing.
+type siteDir string
+
+func (sd siteDir) Open(name string) (http.File, error) {
+	root, err := filepath.EvalSymlinks(string(sd))
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(path.Clean("/"+name))))
+	if err != nil {
+		return nil, err
+	}
+	if !inside(root, resolved) {
+		return nil, os.ErrNotExist
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		if index, err := os.Stat(filepath.Join(resolved, indexFile)); err != nil || index.IsDir() {
+			return nil, os.ErrNotExist
+		}
+	}
+	return os.Open(resolved)
+}
+
+// inside checks that path is root or nested in root.
+func inside(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
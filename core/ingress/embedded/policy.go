@@ -0,0 +1,56 @@
+package embedded
+
+import (
+	"net/http"
+	"sync"
+)
+
+// NewPolicy creates per-group route handler. Requests to groups without specific handler will be served by fallback.
+// Nil fallback means no additional processing.
+func NewPolicy(fallback RouteHandler) *Policy {
+	return &Policy{
+		fallback: fallback,
+		byGroup:  make(map[string]RouteHandler),
+	}
+}
+
+// Policy dispatches requests to handlers by record group. Safe for concurrent usage.
+type Policy struct {
+	lock     sync.RWMutex
+	fallback RouteHandler
+	byGroup  map[string]RouteHandler
+}
+
+// Set handler for the group. Replaces previous one.
+func (pl *Policy) Set(group string, handler RouteHandler) {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+	pl.byGroup[group] = handler
+}
+
+// Remove group specific handler.
+func (pl *Policy) Remove(group string) {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+	delete(pl.byGroup, group)
+}
+
+func (pl *Policy) ServeRoute(writer http.ResponseWriter, request *http.Request, route Route) error {
+	pl.lock.RLock()
+	handler, ok := pl.byGroup[route.Record.Group]
+	pl.lock.RUnlock()
+	if !ok {
+		handler = pl.fallback
+	}
+	if handler == nil {
+		return nil
+	}
+	return handler.ServeRoute(writer, request, route)
+}
+
+// Public handler which allows everything.
+func Public() RouteHandler {
+	return RouteHandlerFunc(func(writer http.ResponseWriter, request *http.Request, record Route) error {
+		return nil
+	})
+}
@@ -7,15 +7,30 @@ import (
 	"errors"
 	"html/template"
 	"net/http"
+	"strconv"
 	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/reddec/git-pipe/core/ingress"
 	"github.com/reddec/git-pipe/internal"
 	"go.uber.org/zap"
 )
 
+//nolint:gochecknoglobals
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitpipe_http_requests_total",
+		Help: "Total number of routed HTTP requests",
+	}, []string{"repo", "domain", "code"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "gitpipe_http_request_duration_seconds",
+		Help: "Routed HTTP requests latency",
+	}, []string{"repo", "domain"})
+)
+
 // New router which can be used independently or as backend for ingress.
 // If rootDomain defined it will be added to all domains in records during Set operation in case path routing disabled.
 func New(requestResolver RequestResolver, chain ...RouteHandler) *Router {
@@ -147,7 +162,10 @@ func (rt *Router) serveRoute(writer http.ResponseWriter, request *http.Request,
 
 	logger.Debug("request started")
 	defer func() {
-		logger.Info("request finished", zap.Int("code", wrapped.statusCode), zap.Duration("duration", time.Since(started)))
+		duration := time.Since(started)
+		requestsTotal.WithLabelValues(record.Group, record.Domain, strconv.Itoa(wrapped.statusCode)).Inc()
+		requestDuration.WithLabelValues(record.Group, record.Domain).Observe(duration.Seconds())
+		logger.Info("request finished", zap.Int("code", wrapped.statusCode), zap.Duration("duration", duration))
 	}()
 
 	request = request.WithContext(internal.WithLogger(ctx, logger))
@@ -2,8 +2,11 @@ package embedded_test
 
 import (
 	"context"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"sync/atomic"
 	"testing"
 
@@ -304,3 +307,92 @@ func TestJWT(t *testing.T) {
 		})
 	})
 }
+
+func TestPolicy(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	require.NoError(t, err)
+	zap.ReplaceGlobals(logger)
+	defer logger.Sync()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"aud": "client1"}).SignedString([]byte("secret-2"))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	policy := embedded.NewPolicy(embedded.JWT("qwerty"))
+	policy.Set("public", embedded.Public())
+	policy.Set("private", embedded.JWT("secret-2"))
+
+	rt := embedded.New(embedded.ByRoot(), policy, embedded.RouteHandlerFunc(func(writer http.ResponseWriter, request *http.Request, record embedded.Route) error {
+		writer.WriteHeader(http.StatusOK)
+		return nil
+	}))
+	err = rt.Set(ctx, []ingress.Record{
+		{Domain: "public.example.com", Group: "public"},
+		{Domain: "private.example.com", Group: "private"},
+		{Domain: "default.example.com", Group: "default"},
+	})
+	require.NoError(t, err)
+
+	t.Run("public group without token", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "https://public.example.com/", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+	t.Run("default group uses fallback", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, "https://default.example.com/", nil)
+		rq.Header.Set("Authorization", "Bearer "+token)
+		rt.ServeHTTP(rr, rq)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+	t.Run("group specific key", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		rq := httptest.NewRequest(http.MethodGet, "https://private.example.com/", nil)
+		rq.Header.Set("Authorization", "Bearer "+token)
+		rt.ServeHTTP(rr, rq)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+	t.Run("removed group uses fallback", func(t *testing.T) {
+		policy.Remove("public")
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "https://public.example.com/", nil))
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+}
+
+func TestFiles(t *testing.T) {
+	root := t.TempDir()
+	site := filepath.Join(root, "site")
+	require.NoError(t, os.MkdirAll(filepath.Join(site, "docs"), 0700))
+	require.NoError(t, os.MkdirAll(filepath.Join(site, "blog"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(site, "index.html"), []byte("home"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(site, "docs", "guide.html"), []byte("guide"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(site, "blog", "index.html"), []byte("blog"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "secret"), []byte("secret"), 0600))
+	require.NoError(t, os.Symlink(filepath.Join(root, "secret"), filepath.Join(site, "absolute.txt")))
+	require.NoError(t, os.Symlink("../secret", filepath.Join(site, "relative.txt")))
+	require.NoError(t, os.Symlink(root, filepath.Join(site, "parent")))
+	require.NoError(t, os.Symlink("docs/guide.html", filepath.Join(site, "guide.html")))
+
+	router := embedded.New(embedded.ByRoot(), embedded.Files())
+	require.NoError(t, router.Set(context.Background(), []ingress.Record{
+		{Domain: "site", Group: "site", Addresses: []string{ingress.FileScheme + site}},
+	}))
+	get := func(path string) (int, string) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://site"+path, nil))
+		return recorder.Code, recorder.Body.String()
+	}
+
+	for path, expected := range map[string]string{"/": "home", "/guide.html": "guide", "/blog/": "blog"} {
+		code, body := get(path)
+		assert.Equal(t, http.StatusOK, code, path)
+		assert.Equal(t, expected, body, path)
+	}
+	for _, path := range []string{"/absolute.txt", "/relative.txt", "/parent/secret", "/docs/", "/parent/"} {
+		code, body := get(path)
+		assert.Equal(t, http.StatusNotFound, code, path)
+		assert.NotContains(t, body, "secret", path)
+		assert.NotContains(t, body, "guide.html", path)
+	}
+}
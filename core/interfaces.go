@@ -40,6 +40,23 @@ type Ingress interface {
 	Set(ctx context.Context, group string, domainAddresses map[string][]string) error
 }
 
+// Forward of host port to container port.
+type Forward struct {
+	Name      string   // unique within group, keeps allocated port between versions (ex: service:port/udp)
+	Protocol  string   // tcp or udp
+	Port      int      // requested host port, 0 means allocate
+	Addresses []string // host:port, could be multiple in case of scale factor > 1
+}
+
+// Forwarder exposes non-HTTP (TCP and UDP) ports on host, where host port is unique reference to service.
+type Forwarder interface {
+	// Clear forwarded ports for the group.
+	Clear(ctx context.Context, group string) error
+	// Set (replaces) forwarded ports for the group. Returns allocated host ports by forward names. Ports not allowed by
+	// implementation are skipped.
+	Set(ctx context.Context, group string, forwards []Forward) (map[string]int, error)
+}
+
 // DNS records management.
 type DNS interface {
 	// Register (updated or add) domains to current IP.
@@ -52,10 +69,19 @@ type Event interface {
 	Ready()
 }
 
+// Pack runs content of working directory (ex: docker-compose project).
+type Pack interface {
+	// Detect returns true if content of the directory could be run by the pack.
+	Detect(directory string) bool
+	// Run package till context canceled. Ready event should be emitted once package is ready.
+	Run(ctx context.Context, env *Environment) error
+}
+
 // Base environment for all instances.
 type Base struct {
 	DNS     DNS              // Register DNS name
 	Ingress Ingress          // allow incoming HTTP(S) traffic to internal service
+	Forward Forwarder        // allow incoming TCP and UDP traffic to internal service
 	Backup  Storage          // backup storage holder
 	Network Network          // docker networking
 	Docker  client.APIClient // docker api
@@ -67,5 +93,8 @@ type Environment struct {
 	Name      string            // unique name of package/group
 	Directory string            // working directory
 	Vars      map[string]string // environment variables
+	Aliases   []string          // additional domains for the root service
 	Event     Event             // event emitter
+	Handover  *Handover         // zero-downtime redeploy state (optional)
+	Manifest  Manifest          // settings from repository
 }
@@ -0,0 +1,222 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ManifestFile is optional file in repository with deployment settings.
+const ManifestFile = ".git-pipe.yaml"
+
+// ImageFile contains reference to prebuilt image. If the file exists in the directory, image is used as is
+// instead of building from Dockerfile.
+const ImageFile = ".image"
+
+var (
+	ErrInvalidManifest = errors.New("invalid manifest")
+	errInvalidSize     = errors.New("invalid size")
+)
+
+// domainLabel is one part of host name: letters, digits and hyphens, without hyphens at the ends.
+var domainLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`) //nolint:gochecknoglobals
+
+const maxDomainLength = 253
+
+// Manifest of repository. Zero value means defaults.
+type Manifest struct {
+	Pack        string      `yaml:"pack"`         // explicit pack name, overrides autodetection
+	Domains     []string    `yaml:"domains"`      // additional domains for the root service
+	Root        Root        `yaml:"root"`         // root service and port
+	Healthcheck Healthcheck `yaml:"healthcheck"`  // HTTP check before switching traffic
+	RequiredEnv []string    `yaml:"required_env"` // environment variables which must be set
+	Backup      Backup      `yaml:"backup"`       // volumes selection for backup
+	Hooks       Hooks       `yaml:"hooks"`        // commands executed in the root container
+	Resources   Resources   `yaml:"resources"`    // limits for each container
+	Static      Static      `yaml:"static"`       // settings for static site
+	Compose     Compose     `yaml:"compose"`      // settings for docker-compose projects
+}
+
+// Root defines service which will be exposed without sub-domain.
+type Root struct {
+	Service string `yaml:"service"` // docker-compose service name
+	Port    int    `yaml:"port"`    // container port
+}
+
+// Healthcheck by HTTP request to the root service. Any 2xx or 3xx status means healthy.
+type Healthcheck struct {
+	Path     string        `yaml:"path"`     // URL path, check disabled if empty
+	Interval time.Duration `yaml:"interval"` // interval between attempts
+	Timeout  time.Duration `yaml:"timeout"`  // total time to become healthy
+}
+
+// Backup volumes filter. Patterns are matched by path.Match against volume names for docker-compose and
+// against VOLUME paths for Dockerfile.
+type Backup struct {
+	Include []string `yaml:"include"` // if not empty, only matched volumes are backed up
+	Exclude []string `yaml:"exclude"` // matched volumes are not backed up
+}
+
+// Hooks are shell commands (sh -c) executed in the root container.
+type Hooks struct {
+	Service     string   `yaml:"service"`      // docker-compose service for hooks, default is root service
+	BeforeReady []string `yaml:"before_ready"` // after start and before traffic switch, failure aborts deployment
+	AfterReady  []string `yaml:"after_ready"`  // after traffic switch, failures are only logged
+}
+
+// Resources limits for each container. Empty values mean no limits.
+type Resources struct {
+	Memory Size    `yaml:"memory"` // memory limit (ex: 512m, 1g)
+	CPUs   float64 `yaml:"cpus"`   // number of CPUs (ex: 0.5)
+}
+
+// Static site settings.
+type Static struct {
+	Dir   string `yaml:"dir"`   // directory with site (relative to repo or to build output), default is public or root
+	Image string `yaml:"image"` // image for build step, build is disabled if empty
+	Build string `yaml:"build"` // build command (sh -c) executed in /src directory with repo content
+}
+
+// Compose project settings.
+type Compose struct {
+	Files    []string `yaml:"files"`    // compose files in merge order, default is compose file and override file
+	Profiles []string `yaml:"profiles"` // enabled profiles
+}
+
+// Size in bytes. Could be defined with binary suffix: b, k, m, g.
+type Size int64
+
+func (sz *Size) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var text string
+	if err := unmarshal(&text); err != nil {
+		return err
+	}
+	value, err := ParseSize(text)
+	if err != nil {
+		return err
+	}
+	*sz = value
+	return nil
+}
+
+// ParseSize parses number of bytes with optional binary suffix (case insensitive): b, k, m, g.
+func ParseSize(text string) (Size, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	multiplier := int64(1)
+	for i, suffix := range []string{"b", "k", "m", "g"} {
+		if strings.HasSuffix(text, suffix) {
+			text = strings.TrimSuffix(text, suffix)
+			multiplier = 1 << (10 * i) //nolint:gomnd
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%w: %q", errInvalidSize, text)
+	}
+	return Size(value * float64(multiplier)), nil
+}
+
+// BackedUp checks that volume (name or path) should be backed up.
+func (bk Backup) BackedUp(volume string) bool {
+	return (len(bk.Include) == 0 || matchAny(bk.Include, volume)) && !matchAny(bk.Exclude, volume)
+}
+
+// Validate manifest content.
+func (mf *Manifest) Validate() error {
+	for _, domain := range mf.Domains {
+		if !ValidDomain(domain) {
+			return fmt.Errorf("%w: invalid domain %q", ErrInvalidManifest, domain)
+		}
+	}
+	patterns := append(append([]string{}, mf.Backup.Include...), mf.Backup.Exclude...)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: backup pattern %q: %v", ErrInvalidManifest, pattern, err)
+		}
+	}
+	if mf.Healthcheck.Path != "" && !strings.HasPrefix(mf.Healthcheck.Path, "/") {
+		return fmt.Errorf("%w: health check path should start from /", ErrInvalidManifest)
+	}
+	if !inside(mf.Static.Dir) {
+		return fmt.Errorf("%w: static dir should be relative", ErrInvalidManifest)
+	}
+	for _, file := range mf.Compose.Files {
+		if file == "" || !inside(file) {
+			return fmt.Errorf("%w: compose file %q should be relative", ErrInvalidManifest, file)
+		}
+	}
+	if (mf.Static.Image == "") != (mf.Static.Build == "") {
+		return fmt.Errorf("%w: static image and build command should be defined together", ErrInvalidManifest)
+	}
+	if mf.Root.Port < 0 || mf.Resources.CPUs < 0 {
+		return fmt.Errorf("%w: negative root port or cpus", ErrInvalidManifest)
+	}
+	return nil
+}
+
+// MissingEnv returns required environment variables which are not defined in vars.
+func (mf *Manifest) MissingEnv(vars map[string]string) []string {
+	var missing []string
+	for _, name := range mf.RequiredEnv {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// LoadManifest from directory. Returns empty manifest if file does not exist.
+func LoadManifest(directory string) (*Manifest, error) {
+	var manifest Manifest
+	data, err := ioutil.ReadFile(filepath.Join(directory, ManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	if err := manifest.Validate(); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// ValidDomain checks that domain is valid lower-case host name.
+func ValidDomain(domain string) bool {
+	if domain == "" || len(domain) > maxDomainLength {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if !domainLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// inside checks that path is relative and does not escape directory.
+func inside(name string) bool {
+	return !filepath.IsAbs(name) && !strings.HasPrefix(path.Clean(filepath.ToSlash(name))+"/", "../")
+}
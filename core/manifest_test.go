@@ -0,0 +1,91 @@
+package core_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reddec/git-pipe/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifest, err := core.LoadManifest(dir)
+	require.NoError(t, err)
+	assert.Equal(t, &core.Manifest{}, manifest, "missing manifest means defaults")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, core.ManifestFile), []byte(`
+pack: compose
+domains: [example.com]
+root:
+  service: web
+  port: 8080
+healthcheck:
+  path: /health
+  interval: 2s
+required_env: [DB_URL]
+backup:
+  exclude: [cache*]
+hooks:
+  before_ready: ["./migrate"]
+resources:
+  memory: 512m
+  cpus: 0.5
+`), 0600))
+
+	manifest, err = core.LoadManifest(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, manifest.Domains)
+	assert.Equal(t, core.Root{Service: "web", Port: 8080}, manifest.Root)
+	assert.Equal(t, 2*time.Second, manifest.Healthcheck.Interval)
+	assert.Equal(t, core.Size(512<<20), manifest.Resources.Memory)
+	assert.Equal(t, []string{"DB_URL"}, manifest.MissingEnv(map[string]string{"PORT": "80"}))
+	assert.Empty(t, manifest.MissingEnv(map[string]string{"DB_URL": ""}))
+	assert.True(t, manifest.Backup.BackedUp("data"))
+	assert.False(t, manifest.Backup.BackedUp("cache-v1"))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, core.ManifestFile), []byte("healthcheck: {path: health}"), 0600))
+	_, err = core.LoadManifest(dir)
+	assert.ErrorIs(t, err, core.ErrInvalidManifest)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, core.ManifestFile), []byte("compose: {files: [compose.yaml, ../../etc/app.yaml]}"), 0600))
+	_, err = core.LoadManifest(dir)
+	assert.ErrorIs(t, err, core.ErrInvalidManifest)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, core.ManifestFile), []byte("unknown: field"), 0600))
+	_, err = core.LoadManifest(dir)
+	assert.Error(t, err)
+}
+
+func TestBackup_BackedUp(t *testing.T) {
+	backup := core.Backup{Include: []string{"/data/*"}, Exclude: []string{"/data/tmp"}}
+	assert.True(t, backup.BackedUp("/data/db"))
+	assert.False(t, backup.BackedUp("/data/tmp"))
+	assert.False(t, backup.BackedUp("/var/log"))
+}
+
+func TestParseSize(t *testing.T) {
+	for text, expected := range map[string]core.Size{"100": 100, "1k": 1024, "1.5M": 3 << 19, "2g": 2 << 30} {
+		size, err := core.ParseSize(text)
+		require.NoError(t, err, text)
+		assert.Equal(t, expected, size, text)
+	}
+	_, err := core.ParseSize("lots")
+	assert.Error(t, err)
+}
+
+func TestValidDomain(t *testing.T) {
+	for _, domain := range []string{"app", "api.app", "example.com", "my-app.example.com", "x1.y2"} {
+		assert.True(t, core.ValidDomain(domain), domain)
+	}
+	for _, domain := range []string{"", ".", "app.", ".app", "app..com", "-app", "app-", "App.com", "app_1", "*.app", "app/../x", "app:80", strings.Repeat("a", 64)} {
+		assert.False(t, core.ValidDomain(domain), domain)
+	}
+
+	manifest := core.Manifest{Domains: []string{"api.app", ""}}
+	assert.ErrorIs(t, manifest.Validate(), core.ErrInvalidManifest)
+}
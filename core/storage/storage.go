@@ -15,11 +15,34 @@ import (
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/reddec/git-pipe/backup"
 	"github.com/reddec/git-pipe/cryptor"
 	"github.com/reddec/git-pipe/internal"
 )
 
+//nolint:gochecknoglobals
+var (
+	backupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitpipe_backups_total",
+		Help: "Total number of backups",
+	}, []string{"repo"})
+	backupFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitpipe_backup_failures_total",
+		Help: "Total number of failed backups",
+	}, []string{"repo"})
+	backupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gitpipe_backup_duration_seconds",
+		Help:    "Backup duration including upload",
+		Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}, //nolint:gomnd
+	}, []string{"repo"})
+	backupSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gitpipe_backup_size_bytes",
+		Help: "Size of the last successful backup archive (encrypted)",
+	}, []string{"repo"})
+)
+
 func Default(provider backup.Backup, cli *client.Client, encryption cryptor.Cryptor) *VolumeStorage {
 	return New(provider, cli, encryption, "", "local", time.Hour)
 }
@@ -82,38 +105,56 @@ func (sw *VolumeStorage) Restore(ctx context.Context, name string, volumeNames [
 }
 
 func (sw *VolumeStorage) Backup(ctx context.Context, name string, volumeNames []string) error {
+	started := time.Now()
+	size, err := sw.backup(ctx, name, volumeNames)
+	backupsTotal.WithLabelValues(name).Inc()
+	backupDuration.WithLabelValues(name).Observe(time.Since(started).Seconds())
+	if err != nil {
+		backupFailures.WithLabelValues(name).Inc()
+		return err
+	}
+	backupSize.WithLabelValues(name).Set(float64(size))
+	return nil
+}
+
+func (sw *VolumeStorage) backup(ctx context.Context, name string, volumeNames []string) (int64, error) {
 	rawFile, err := ioutil.TempFile(sw.tempDir, "")
 	if err != nil {
-		return fmt.Errorf("create temp archive: %w", err)
+		return 0, fmt.Errorf("create temp archive: %w", err)
 	}
 
 	if err := rawFile.Close(); err != nil {
-		return fmt.Errorf("close raw file: %w", err)
+		return 0, fmt.Errorf("close raw file: %w", err)
 	}
 
 	defer os.RemoveAll(rawFile.Name())
 
 	if err := sw.copyVolumesToArchive(ctx, volumeNames, rawFile.Name()); err != nil {
-		return fmt.Errorf("copy volumes to archive: %w", err)
+		return 0, fmt.Errorf("copy volumes to archive: %w", err)
 	}
 
 	encryptedFile, err := ioutil.TempFile(sw.tempDir, "")
 	if err != nil {
-		return fmt.Errorf("create temp encrypted: %w", err)
+		return 0, fmt.Errorf("create temp encrypted: %w", err)
 	}
 	if err := encryptedFile.Close(); err != nil {
-		return fmt.Errorf("close encrypted file: %w", err)
+		return 0, fmt.Errorf("close encrypted file: %w", err)
 	}
 	defer os.RemoveAll(encryptedFile.Name())
 
 	if err := sw.encryption.Encrypt(ctx, rawFile.Name(), encryptedFile.Name()); err != nil {
-		return fmt.Errorf("encrypt archive file: %w", err)
+		return 0, fmt.Errorf("encrypt archive file: %w", err)
+	}
+
+	info, err := os.Stat(encryptedFile.Name())
+	if err != nil {
+		return 0, fmt.Errorf("stat encrypted file: %w", err)
 	}
 
 	if err := sw.provider.Backup(ctx, name, encryptedFile.Name()); err != nil {
-		return fmt.Errorf("upload archive: %w", err)
+		return 0, fmt.Errorf("upload archive: %w", err)
 	}
-	return nil
+	return info.Size(), nil
 }
 
 func (sw *VolumeStorage) Schedule(ctx context.Context, name string, volumeNames []string) *internal.Task {
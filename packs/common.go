@@ -1,5 +1,16 @@
 package packs
 
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/reddec/git-pipe/core"
+)
+
 func PortsPriority() []int {
 	return []int{80, 8080}
 }
@@ -7,3 +18,91 @@ func PortsPriority() []int {
 func NamePriority() []string {
 	return []string{"www", "web", "gateway"}
 }
+
+// RootPortsPriority is PortsPriority with root port from manifest (if defined) as the first one.
+func RootPortsPriority(env *core.Environment) []int {
+	if env.Manifest.Root.Port > 0 {
+		return append([]int{env.Manifest.Root.Port}, PortsPriority()...)
+	}
+	return PortsPriority()
+}
+
+// WithAliases adds environment and manifest aliases to the exposed domains. Aliases are pointing to the same addresses
+// as root domain. Nothing will be added if root domain not exposed. Manifest domains which are not allowed for the repo
+// (see AllowedDomain) are ignored.
+func WithAliases(env *core.Environment, addressesByDomain map[string][]string) map[string][]string {
+	root, ok := addressesByDomain[env.Name]
+	if !ok {
+		return addressesByDomain
+	}
+	aliases := append([]string{}, env.Aliases...)
+	for _, domain := range env.Manifest.Domains {
+		if AllowedDomain(env, domain) {
+			aliases = append(aliases, domain)
+		}
+	}
+	for _, alias := range aliases {
+		if _, exists := addressesByDomain[alias]; !exists {
+			addressesByDomain[alias] = root
+		}
+	}
+	return addressesByDomain
+}
+
+// ClearIngress removes routes of the package unless the package was replaced by the next generation which owns
+// the routes now. If the package failed while the previous generation is still running, routes of the previous
+// generation are restored.
+func ClearIngress(env *core.Environment) {
+	if env.Handover.Replaced() {
+		return
+	}
+	if previous, ok := env.Handover.Previous(); ok {
+		_ = env.Ingress.Set(context.Background(), env.Name, previous.Domains)
+		return
+	}
+	_ = env.Ingress.Clear(context.Background(), env.Name)
+}
+
+// ClearForwards removes forwarded ports of the package the same way as ClearIngress removes routes.
+func ClearForwards(env *core.Environment) {
+	if env.Handover.Replaced() {
+		return
+	}
+	if previous, ok := env.Handover.Previous(); ok {
+		_, _ = env.Forward.Set(context.Background(), env.Name, previous.Forwards)
+		return
+	}
+	_ = env.Forward.Clear(context.Background(), env.Name)
+}
+
+// SetForwards replaces forwarded ports of the package. Empty list removes ports of the previous version.
+func SetForwards(ctx context.Context, env *core.Environment, forwards []core.Forward) error {
+	if _, err := env.Forward.Set(ctx, env.Name, forwards); err != nil {
+		return fmt.Errorf("set forwards: %w", err)
+	}
+	return nil
+}
+
+// GetImage from local storage. Public images are pulled if needed.
+func GetImage(ctx context.Context, cli client.APIClient, ref string) (types.ImageInspect, error) {
+	info, _, err := cli.ImageInspectWithRaw(ctx, ref)
+	if err == nil {
+		return info, nil
+	}
+	if !client.IsErrNotFound(err) {
+		return types.ImageInspect{}, fmt.Errorf("inspect: %w", err)
+	}
+	stream, err := cli.ImagePull(ctx, ref, types.ImagePullOptions{})
+	if err != nil {
+		return types.ImageInspect{}, fmt.Errorf("pull: %w", err)
+	}
+	defer stream.Close()
+	if _, err := io.Copy(ioutil.Discard, stream); err != nil {
+		return types.ImageInspect{}, fmt.Errorf("pull: %w", err)
+	}
+	info, _, err = cli.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return types.ImageInspect{}, fmt.Errorf("inspect: %w", err)
+	}
+	return info, nil
+}
@@ -0,0 +1,70 @@
+package packs_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/reddec/git-pipe/core"
+	"github.com/reddec/git-pipe/core/forward"
+	"github.com/reddec/git-pipe/core/ingress"
+	"github.com/reddec/git-pipe/packs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type routingTable struct {
+	records []ingress.Record
+}
+
+func (rt *routingTable) Set(_ context.Context, records []ingress.Record) error {
+	rt.records = records
+	return nil
+}
+
+func (rt *routingTable) domains() map[string][]string {
+	var ans = make(map[string][]string)
+	for _, record := range rt.records {
+		ans[record.Domain] = record.Addresses
+	}
+	return ans
+}
+
+func TestClearIngress(t *testing.T) {
+	ctx := context.Background()
+	table := &routingTable{}
+	base := core.Base{
+		Ingress: ingress.New(table),
+		Forward: forward.New("127.0.0.1", forward.Range{}, nil),
+	}
+	previousRoutes := map[string][]string{"app": {"10.0.0.1:80"}}
+
+	previous := core.NewHandover(nil, nil)
+	require.NoError(t, base.Ingress.Set(ctx, "app", previousRoutes))
+	previous.Publish(core.Routes{Domains: previousRoutes})
+
+	t.Run("failed next generation restores routes of running previous", func(t *testing.T) {
+		next := &core.Environment{Base: base, Name: "app", Handover: core.NewHandover(previous, func() {})}
+		require.NoError(t, base.Ingress.Set(ctx, "app", map[string][]string{"app": {"10.0.0.2:80"}}))
+
+		packs.ClearIngress(next)
+		packs.ClearForwards(next)
+		assert.Equal(t, previousRoutes, table.domains())
+	})
+
+	t.Run("failed next generation clears routes if previous finished", func(t *testing.T) {
+		previous.Finish()
+		next := &core.Environment{Base: base, Name: "app", Handover: core.NewHandover(previous, func() {})}
+
+		packs.ClearIngress(next)
+		assert.Empty(t, table.domains())
+	})
+
+	t.Run("replaced generation keeps routes", func(t *testing.T) {
+		current := &core.Environment{Base: base, Name: "app", Handover: core.NewHandover(nil, nil)}
+		require.NoError(t, base.Ingress.Set(ctx, "app", previousRoutes))
+		current.Handover.Replace()
+
+		packs.ClearIngress(current)
+		assert.Equal(t, previousRoutes, table.domains())
+	})
+}
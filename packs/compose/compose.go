@@ -18,16 +18,47 @@ import (
 	"github.com/reddec/git-pipe/core"
 	"github.com/reddec/git-pipe/internal"
 	"github.com/reddec/git-pipe/packs"
-	"gopkg.in/yaml.v2"
 )
 
+// Name of the pack.
+const Name = "compose"
+
+var (
+	errRootNotExposed   = errors.New("root service from manifest has no exposed ports")
+	errNoHooksContainer = errors.New("no container for hooks")
+	errFileOutside      = errors.New("compose file outside of repository")
+)
+
+//nolint:gochecknoglobals
+var (
+	composeFiles  = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+	overrideFiles = []string{"compose.override.yaml", "compose.override.yml", "docker-compose.override.yaml", "docker-compose.override.yml"}
+)
+
+// Environment variables with the same meaning as for docker-compose.
+const (
+	envComposeFile   = "COMPOSE_FILE"
+	envPathSeparator = "COMPOSE_PATH_SEPARATOR"
+	envProfiles      = "COMPOSE_PROFILES"
+)
+
+// Pack for docker-compose projects.
+type Pack struct{}
+
+// Detect docker-compose file.
+func (Pack) Detect(directory string) bool {
+	return packs.HasAnyFile(directory, composeFiles...)
+}
+
+func (Pack) Run(ctx context.Context, env *core.Environment) error {
+	return Run(ctx, env)
+}
+
 func Run(ctx context.Context, env *core.Environment) error {
 	rootDir, err := filepath.Abs(env.Directory)
 	if err != nil {
 		return fmt.Errorf("detect root path of workdir: %w", err)
 	}
-	at := internal.At(env.Directory)
-
 	// Read environment file if possible
 	environ, err := internal.ReadEnvFile(filepath.Join(rootDir, ".env"))
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
@@ -44,32 +75,41 @@ func Run(ctx context.Context, env *core.Environment) error {
 	}
 	env.Vars = merged
 
-	// Read first compose file
-	fileName, content, err := readComposeFile(env.Directory, "docker-compose.yaml", "docker-compose.yml")
+	if err := packs.CheckEnv(env); err != nil {
+		return err
+	}
+
+	// Read compose files: explicit list or the first compose file with override
+	fileNames, err := composeFileNames(env)
+	if err != nil {
+		return fmt.Errorf("find compose files: %w", err)
+	}
+	configFiles, err := readComposeFiles(rootDir, fileNames)
 	if err != nil {
 		return fmt.Errorf("read compose file: %w", err)
 	}
 
+	load := func() (*types.Project, error) {
+		project, err := loader.Load(types.ConfigDetails{
+			WorkingDir:  rootDir,
+			Environment: env.Vars,
+			ConfigFiles: configFiles,
+		})
+		if err != nil {
+			return nil, err
+		}
+		project.ApplyProfiles(composeProfiles(env))
+		return project, nil
+	}
+
 	// Parse config
-	project, err := loader.Load(types.ConfigDetails{
-		Environment: env.Vars,
-		ConfigFiles: []types.ConfigFile{{
-			Filename: fileName,
-			Content:  content,
-		}},
-	})
+	project, err := load()
 	if err != nil {
 		return fmt.Errorf("load compose config: %w", err)
 	}
 
 	// Lazy clone project
-	modified, err := loader.Load(types.ConfigDetails{
-		Environment: env.Vars,
-		ConfigFiles: []types.ConfigFile{{
-			Filename: fileName,
-			Content:  content,
-		}},
-	})
+	modified, err := load()
 	if err != nil {
 		return fmt.Errorf("load compose config (2): %w", err)
 	}
@@ -78,17 +118,30 @@ func Run(ctx context.Context, env *core.Environment) error {
 	var volumes []string
 	for name, volume := range modified.Volumes {
 		if !volume.External.External && (volume.Driver == "" || volume.Driver == "local") {
-			if strings.HasPrefix(volume.Name, "_") {
+			if volume.Name == "" || strings.HasPrefix(volume.Name, "_") {
 				// Workaround to provide valid volume name
-				volume.Name = env.Name + volume.Name
+				volume.Name = env.Name + "_" + name
 				modified.Volumes[name] = volume
 			}
-			volumes = append(volumes, volume.Name)
+			if env.Manifest.Backup.BackedUp(name) {
+				volumes = append(volumes, volume.Name)
+			}
+		}
+	}
+
+	// Networks are named the same way as volumes
+	for name, network := range modified.Networks {
+		if !network.External.External && (network.Name == "" || strings.HasPrefix(network.Name, "_")) {
+			network.Name = env.Name + "_" + name
+			modified.Networks[name] = network
 		}
 	}
 
 	// Apply service bind workaround
 	for name, service := range modified.Services {
+		if service.Build != nil && !filepath.IsAbs(service.Build.Context) {
+			service.Build.Context = filepath.Join(rootDir, service.Build.Context)
+		}
 		for i, volume := range service.Volumes {
 			if volume.Type == types.VolumeTypeBind {
 				if volume.Bind != nil {
@@ -104,42 +157,52 @@ func Run(ctx context.Context, env *core.Environment) error {
 		modified.Services[name] = service
 	}
 
-	// Remove published ports
+	// Apply default limits. Ports are never published by docker: traffic goes through ingress or forwarder.
+	limits := env.Manifest.Resources
 	for i, srv := range modified.Services {
-		srv.Ports = nil
+		if srv.MemLimit == 0 {
+			srv.MemLimit = types.UnitBytes(limits.Memory)
+		}
+		if srv.CPUS == 0 {
+			srv.CPUS = float32(limits.CPUs)
+		}
 		modified.Services[i] = srv
 	}
 
-	// Serialize updated config
-	composeContent, err := yaml.Marshal(modified)
-	if err != nil {
-		return fmt.Errorf("marshall compose config: %w", err)
+	deployment := newEngine(env.Docker, env.Name, modified, env.Vars)
+
+	// Build while previous version (if any) is still running
+	if err := deployment.Build(ctx); err != nil {
+		return fmt.Errorf("build: %w", err)
 	}
 
-	// Recover volumes (if applicable)
-	err = env.Backup.Restore(ctx, env.Name, volumes)
-	if err != nil {
-		return fmt.Errorf("restore: %w", err)
+	// Project can not run side by side with previous version
+	env.Handover.StopPrevious()
+
+	// Recover volumes (if applicable). During takeover volumes are already initialized by the previous version.
+	if !env.Handover.Takeover() {
+		err = env.Backup.Restore(ctx, env.Name, volumes)
+		if err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
 	}
 
 	// Schedule backup
 	backupTask := env.Backup.Schedule(ctx, env.Name, volumes)
 	defer backupTask.Stop()
 
-	// Build
-	err = at.Do(ctx, "docker-compose", "-f", "-", "-p", env.Name, "build", "--pull", "--force-rm").Env(env.Vars).Input(composeContent).Exec()
-	if err != nil {
-		return fmt.Errorf("build image: %w", err)
-	}
+	// Tear down automatically (including partially started project)
+	defer deployment.Down(context.Background()) //nolint:errcheck
 
 	// Bring up
-	err = at.Do(ctx, "docker-compose", "-f", "-", "-p", env.Name, "up", "-d", "--remove-orphans").Env(env.Vars).Input(composeContent).Exec()
-	if err != nil {
+	if err := deployment.Up(ctx); err != nil {
 		return fmt.Errorf("bring up: %w", err)
 	}
 
-	// Tear down automatically
-	defer at.Do(context.Background(), "docker-compose", "-f", "-", "-p", env.Name, "stop").Env(env.Vars).Input(composeContent).Exec()
+	// Do not expose half-started project
+	if err := deployment.WaitHealthy(ctx); err != nil {
+		return fmt.Errorf("wait for healthy services: %w", err)
+	}
 
 	// Get deployed containers
 	containers, err := mapContainers(ctx, env.Docker, env.Name, project.Services)
@@ -148,21 +211,30 @@ func Run(ctx context.Context, env *core.Environment) error {
 	}
 
 	// Collect exposed domains
-	var firstDomainByService = make(map[string]string) // service -> domain
-	var exposedLinks = make(map[string][]string)       // domains -> addresses
+	var rootDomainByService = make(map[string]string) // service -> domain of root (manifest or first) port
+	var exposedLinks = make(map[string][]string)      // domains -> addresses
+	var forwards []core.Forward                       // published and UDP ports
+	var rootService string
 	for _, serviceContainers := range containers {
 		var ports []types.ServicePortConfig
+		var forwarded []types.ServicePortConfig
 
 		for _, port := range serviceContainers.Service.Ports {
-			// Filter only tcp or empty protocol
-			if port.Protocol != "tcp" && port.Protocol != "" {
-				continue
+			switch port.Protocol {
+			case "udp":
+				// UDP can not be routed by HTTP router, so it's always forwarded from host
+				forwarded = append(forwarded, port)
+			case "tcp", "":
+				ports = append(ports, port)
+				// Published TCP ports are additionally forwarded from host
+				if port.Published != 0 {
+					forwarded = append(forwarded, port)
+				}
 			}
-			ports = append(ports, port)
 		}
 
 		// Filter only exposed
-		if len(ports) == 0 {
+		if len(ports) == 0 && len(forwarded) == 0 {
 			continue
 		}
 
@@ -179,6 +251,21 @@ func Run(ctx context.Context, env *core.Environment) error {
 			defer env.Network.Leave(ctx, container.ID)
 		}
 
+		for _, port := range forwarded {
+			protocol := any(port.Protocol, "tcp")
+			forwards = append(forwards, core.Forward{
+				Name:      serviceContainers.Service.Name + ":" + strconv.FormatUint(uint64(port.Target), 10) + "/" + protocol, //nolint:gomnd
+				Protocol:  protocol,
+				Port:      int(port.Published),
+				Addresses: mapLinks(links, port.Target),
+			})
+		}
+
+		// Only TCP ports are routed by HTTP router
+		if len(ports) == 0 {
+			continue
+		}
+
 		// Allocate domains
 		domain := domainName(env.Name, any(serviceContainers.Service.DomainName, serviceContainers.Service.Name))
 		domainsByPort := make(map[int]string)
@@ -186,14 +273,14 @@ func Run(ctx context.Context, env *core.Environment) error {
 			serviceDomain := domainName(domain, strconv.FormatUint(uint64(port.Target), 10)) //nolint:gomnd
 			exposedLinks[serviceDomain] = mapLinks(links, port.Target)
 
-			if i == 0 {
-				firstDomainByService[serviceContainers.Service.Name] = serviceDomain
+			if i == 0 || int(port.Target) == env.Manifest.Root.Port {
+				rootDomainByService[serviceContainers.Service.Name] = serviceDomain
 			}
 			domainsByPort[int(port.Target)] = serviceDomain
 		}
 
 		// Try pick root domain for service by ports priority
-		for _, port := range packs.PortsPriority() {
+		for _, port := range packs.RootPortsPriority(env) {
 			if serviceDomain, ok := domainsByPort[port]; ok {
 				exposedLinks[domain] = exposedLinks[serviceDomain]
 				break
@@ -207,22 +294,56 @@ func Run(ctx context.Context, env *core.Environment) error {
 
 		// Check that service marked as root
 		if isRoot, ok := serviceContainers.Service.Extensions["x-root"].(bool); ok && isRoot {
-			exposedLinks[env.Name] = mapLinks(links, ports[0].Target)
+			exposedLinks[env.Name] = exposedLinks[rootDomainByService[serviceContainers.Service.Name]]
+			rootService = serviceContainers.Service.Name
 		}
 	}
 
 	// Pick root domain by name if not yet picked
-	suggestedRootDomain := selectRootDomain(firstDomainByService)
-	if _, picked := exposedLinks[env.Name]; !picked && suggestedRootDomain != "" {
-		exposedLinks[env.Name] = exposedLinks[suggestedRootDomain]
+	suggestedRootService := selectRootService(rootDomainByService)
+	if _, picked := exposedLinks[env.Name]; !picked && suggestedRootService != "" {
+		exposedLinks[env.Name] = exposedLinks[rootDomainByService[suggestedRootService]]
+		rootService = suggestedRootService
+	}
+
+	// Root service from manifest has the highest priority
+	if service := env.Manifest.Root.Service; service != "" {
+		domain, ok := rootDomainByService[service]
+		if !ok {
+			return fmt.Errorf("%w: %s", errRootNotExposed, service)
+		}
+		exposedLinks[env.Name] = exposedLinks[domain]
+		rootService = service
 	}
 
+	// Wait for health check from manifest
+	if err := packs.WaitHealthy(ctx, env, exposedLinks[env.Name]); err != nil {
+		return err
+	}
+
+	hookTarget, err := hooksContainer(env, containers, rootService)
+	if err != nil {
+		return err
+	}
+	if err := packs.BeforeReady(ctx, env, hookTarget); err != nil {
+		return err
+	}
+
+	// Forward non-HTTP ports from host
+	if err := packs.SetForwards(ctx, env, forwards); err != nil {
+		return err
+	}
+	defer packs.ClearForwards(env)
+
+	// Add domain aliases for root domain
+	exposedLinks = packs.WithAliases(env, exposedLinks)
+
 	// Register containers in ingress
 	err = env.Ingress.Set(ctx, env.Name, exposedLinks)
 	if err != nil {
 		return fmt.Errorf("set ingress: %w", err)
 	}
-	defer env.Ingress.Clear(context.Background(), env.Name)
+	defer packs.ClearIngress(env)
 
 	// Register in DNS
 	var domains = make([]string, 0, len(exposedLinks))
@@ -234,8 +355,12 @@ func Run(ctx context.Context, env *core.Environment) error {
 		return fmt.Errorf("register DNS records: %w", err)
 	}
 
+	// Routes could be restored by the next version if it fails
+	env.Handover.Publish(core.Routes{Domains: exposedLinks, Forwards: forwards})
+
 	// Notify that system is up
 	env.Event.Ready()
+	packs.AfterReady(ctx, env, hookTarget)
 
 	// Wait till the end
 	<-ctx.Done()
@@ -258,10 +383,6 @@ func mapLinks(links []string, port uint32) []string {
 }
 
 func mapContainers(ctx context.Context, cli client.APIClient, project string, services []types.ServiceConfig) (map[string]*serviceContainers, error) {
-	const (
-		labelProject = "com.docker.compose.project"
-		labelName    = "com.docker.compose.service"
-	)
 	filter := filters.NewArgs(filters.Arg("label", labelProject+"="+project))
 
 	list, err := cli.ContainerList(ctx, dockerTypes.ContainerListOptions{
@@ -280,7 +401,7 @@ func mapContainers(ctx context.Context, cli client.APIClient, project string, se
 
 	// Map containers (could be multiple) to the service
 	for _, container := range list {
-		serviceName := container.Labels[labelName]
+		serviceName := container.Labels[labelService]
 		info, known := servicesByName[serviceName]
 		if !known {
 			continue
@@ -298,16 +419,29 @@ func mapContainers(ctx context.Context, cli client.APIClient, project string, se
 	return result, nil
 }
 
-func selectRootDomain(domainByService map[string]string) string {
+func selectRootService(domainByService map[string]string) string {
 	for _, name := range packs.NamePriority() {
-		domain, ok := domainByService[name]
-		if ok {
-			return domain
+		if _, ok := domainByService[name]; ok {
+			return name
 		}
 	}
 	return ""
 }
 
+// hooksContainer is the first container of service from manifest hooks or root service. Empty if there are no hooks.
+func hooksContainer(env *core.Environment, containers map[string]*serviceContainers, rootService string) (string, error) {
+	hooks := env.Manifest.Hooks
+	if len(hooks.BeforeReady) == 0 && len(hooks.AfterReady) == 0 {
+		return "", nil
+	}
+	service := any(hooks.Service, rootService)
+	srv, ok := containers[service]
+	if !ok || len(srv.Containers) == 0 {
+		return "", fmt.Errorf("%w: %q", errNoHooksContainer, service)
+	}
+	return srv.Containers[0].ID, nil
+}
+
 func any(options ...string) string {
 	for _, opt := range options {
 		if opt != "" {
@@ -324,15 +458,59 @@ func domainName(root string, name string) string {
 	return root
 }
 
-func readComposeFile(dir string, names ...string) (string, []byte, error) {
+// composeFileNames in merge order: from COMPOSE_FILE variable, from manifest or the first existing compose file with
+// the first existing override file.
+func composeFileNames(env *core.Environment) ([]string, error) {
+	if value := env.Vars[envComposeFile]; value != "" {
+		separator := env.Vars[envPathSeparator]
+		if separator == "" {
+			separator = string(os.PathListSeparator)
+		}
+		return strings.Split(value, separator), nil
+	}
+	if files := env.Manifest.Compose.Files; len(files) > 0 {
+		return files, nil
+	}
+	base := firstFile(env.Directory, composeFiles)
+	if base == "" {
+		return nil, os.ErrNotExist
+	}
+	names := []string{base}
+	if override := firstFile(env.Directory, overrideFiles); override != "" {
+		names = append(names, override)
+	}
+	return names, nil
+}
+
+// composeProfiles from COMPOSE_PROFILES variable or from manifest.
+func composeProfiles(env *core.Environment) []string {
+	if value := env.Vars[envProfiles]; value != "" {
+		return strings.Split(value, ",")
+	}
+	return env.Manifest.Compose.Profiles
+}
+
+func firstFile(dir string, names []string) string {
+	for _, name := range names {
+		if packs.HasAnyFile(dir, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+func readComposeFiles(dir string, names []string) ([]types.ConfigFile, error) {
+	var files = make([]types.ConfigFile, 0, len(names))
 	for _, name := range names {
-		data, err := ioutil.ReadFile(filepath.Join(dir, name))
-		if err == nil {
-			return name, data, nil
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if rel, err := filepath.Rel(dir, file); err != nil || strings.HasPrefix(rel, "..") {
+			return nil, fmt.Errorf("%w: %s", errFileOutside, name)
 		}
-		if !errors.Is(err, os.ErrNotExist) {
-			return "", nil, fmt.Errorf("read compose file: %w", err)
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", name, err)
 		}
+		files = append(files, types.ConfigFile{Filename: file, Content: data})
 	}
-	return "", nil, os.ErrNotExist
+	return files, nil
 }
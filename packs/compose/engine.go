@@ -0,0 +1,838 @@
+package compose
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/compose-spec/compose-go/types"
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/go-units"
+	"github.com/hashicorp/go-multierror"
+	"github.com/reddec/git-pipe/internal"
+	"github.com/reddec/git-pipe/packs"
+	"go.uber.org/zap"
+)
+
+// Labels used by docker-compose. Containers created by git-pipe have the same labels, so tools like `docker compose ls`
+// could recognize them.
+const (
+	labelProject = "com.docker.compose.project"
+	labelService = "com.docker.compose.service"
+	labelNumber  = "com.docker.compose.container-number"
+	labelOneOff  = "com.docker.compose.oneoff"
+	labelNetwork = "com.docker.compose.network"
+	labelVolume  = "com.docker.compose.volume"
+)
+
+const defaultNetwork = "default"
+
+// Conditions of depends_on.
+const (
+	conditionHealthy   = "service_healthy"
+	conditionCompleted = "service_completed_successfully"
+)
+
+const healthPollInterval = 500 * time.Millisecond
+
+var (
+	ErrDependencyCycle  = errors.New("dependency cycle")
+	ErrUnknownNetwork   = errors.New("unknown network")
+	ErrExternalNotFound = errors.New("external resource not found")
+	ErrBuild            = errors.New("build failed")
+	ErrUnhealthy        = errors.New("container is unhealthy")
+	ErrExited           = errors.New("container exited")
+	ErrNoHealthcheck    = errors.New("container has no healthcheck")
+	ErrContainerName    = errors.New("container_name can not be used with multiple replicas")
+	ErrServiceNotFound  = errors.New("referenced service has no containers")
+)
+
+// engine runs compose project directly through docker API.
+type engine struct {
+	docker  client.APIClient
+	name    string              // project name
+	project *types.Project      // project with normalized volumes, networks and paths
+	vars    map[string]string   // environment for variables without value
+	created []string            // containers created by the engine
+	byName  map[string][]string // service -> containers
+}
+
+func newEngine(docker client.APIClient, name string, project *types.Project, vars map[string]string) *engine {
+	return &engine{
+		docker:  docker,
+		name:    name,
+		project: project,
+		vars:    vars,
+		byName:  make(map[string][]string),
+	}
+}
+
+// Build images for services with build section and pull missing images for others.
+func (eng *engine) Build(ctx context.Context) error {
+	for _, service := range eng.project.Services {
+		if service.Build == nil {
+			if _, err := packs.GetImage(ctx, eng.docker, service.Image); err != nil {
+				return fmt.Errorf("get image for service %s: %w", service.Name, err)
+			}
+			continue
+		}
+		if err := eng.build(ctx, service); err != nil {
+			return fmt.Errorf("build service %s: %w", service.Name, err)
+		}
+	}
+	return nil
+}
+
+// Up creates networks and volumes, removes old containers of the project and starts services in dependency order.
+// Services are started once dependencies satisfied conditions (healthy or completed) from depends_on.
+func (eng *engine) Up(ctx context.Context) error {
+	if err := eng.createNetworks(ctx); err != nil {
+		return err
+	}
+	if err := eng.createVolumes(ctx); err != nil {
+		return err
+	}
+	if err := eng.removeContainers(ctx); err != nil {
+		return fmt.Errorf("remove old containers: %w", err)
+	}
+	services, err := startOrder(eng.project.Services)
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		if err := eng.waitDependencies(ctx, service); err != nil {
+			return fmt.Errorf("service %s: %w", service.Name, err)
+		}
+		if err := eng.start(ctx, service); err != nil {
+			return fmt.Errorf("start service %s: %w", service.Name, err)
+		}
+	}
+	return nil
+}
+
+// Down stops and removes containers created by the engine. Networks and volumes are kept for the next version.
+func (eng *engine) Down(ctx context.Context) error {
+	var all *multierror.Error
+	for i := len(eng.created) - 1; i >= 0; i-- {
+		id := eng.created[i]
+		if err := eng.docker.ContainerStop(ctx, id, nil); err != nil && !client.IsErrNotFound(err) {
+			all = multierror.Append(all, fmt.Errorf("stop container %s: %w", id, err))
+		}
+		err := eng.docker.ContainerRemove(ctx, id, dockerTypes.ContainerRemoveOptions{Force: true})
+		if err != nil && !client.IsErrNotFound(err) {
+			all = multierror.Append(all, fmt.Errorf("remove container %s: %w", id, err))
+		}
+	}
+	eng.created = nil
+	eng.byName = make(map[string][]string)
+	return all.ErrorOrNil()
+}
+
+// WaitHealthy waits till all started containers with health check become healthy.
+func (eng *engine) WaitHealthy(ctx context.Context) error {
+	for _, id := range eng.created {
+		if err := eng.waitHealthy(ctx, id, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitDependencies of service by depends_on conditions. Dependencies are already started.
+func (eng *engine) waitDependencies(ctx context.Context, service types.ServiceConfig) error {
+	names := make([]string, 0, len(service.DependsOn))
+	for name := range service.DependsOn {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		condition := service.DependsOn[name].Condition
+		for _, id := range eng.byName[name] {
+			var err error
+			switch condition {
+			case conditionHealthy:
+				internal.LoggerFromContext(ctx).Info("waiting for dependency to be healthy", zap.String("service", service.Name), zap.String("dependency", name))
+				err = eng.waitHealthy(ctx, id, true)
+			case conditionCompleted:
+				internal.LoggerFromContext(ctx).Info("waiting for dependency to complete", zap.String("service", service.Name), zap.String("dependency", name))
+				err = eng.waitCompleted(ctx, id)
+			}
+			if err != nil {
+				return fmt.Errorf("dependency %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// waitHealthy waits till container becomes healthy. Containers without health check are ignored unless required.
+func (eng *engine) waitHealthy(ctx context.Context, containerID string, required bool) error {
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+	for {
+		info, err := eng.docker.ContainerInspect(ctx, containerID)
+		if err != nil {
+			return fmt.Errorf("inspect container: %w", err)
+		}
+		state := info.State
+		switch {
+		case state.Health == nil && required:
+			return fmt.Errorf("%w: %s", ErrNoHealthcheck, info.Name)
+		case state.Health == nil:
+			return nil
+		case state.Health.Status == dockerTypes.Healthy:
+			return nil
+		case state.Health.Status == dockerTypes.Unhealthy:
+			return fmt.Errorf("%w: %s", ErrUnhealthy, info.Name)
+		case !state.Running && !state.Restarting:
+			return fmt.Errorf("%w: %s with code %d", ErrExited, info.Name, state.ExitCode)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitCompleted waits till container exits with zero code.
+func (eng *engine) waitCompleted(ctx context.Context, containerID string) error {
+	okC, errC := eng.docker.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
+	select {
+	case status := <-okC:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("%w: code %d", ErrExited, status.StatusCode)
+		}
+		return nil
+	case err := <-errC:
+		return fmt.Errorf("wait container: %w", err)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (eng *engine) build(ctx context.Context, service types.ServiceConfig) error {
+	logger := internal.LoggerFromContext(ctx).With(zap.String("service", service.Name))
+	buildContext := service.Build.Context
+	excludes, err := readDockerIgnore(buildContext)
+	if err != nil {
+		return err
+	}
+	content, err := archive.TarWithOptions(buildContext, &archive.TarOptions{ExcludePatterns: excludes})
+	if err != nil {
+		return fmt.Errorf("archive build context: %w", err)
+	}
+	defer content.Close()
+
+	labels := map[string]string{labelProject: eng.name, labelService: service.Name}
+	for k, v := range service.Build.Labels {
+		labels[k] = v
+	}
+
+	logger.Info("building image", zap.String("image", eng.image(service)))
+	res, err := eng.docker.ImageBuild(ctx, content, dockerTypes.ImageBuildOptions{
+		Tags:        []string{eng.image(service)},
+		Dockerfile:  service.Build.Dockerfile,
+		BuildArgs:   eng.resolve(service.Build.Args),
+		Target:      service.Build.Target,
+		Labels:      labels,
+		CacheFrom:   service.Build.CacheFrom,
+		ExtraHosts:  service.Build.ExtraHosts,
+		NetworkMode: service.Build.Network,
+		PullParent:  true,
+		Remove:      true,
+		ForceRemove: true,
+	})
+	if err != nil {
+		return fmt.Errorf("build image: %w", err)
+	}
+	defer res.Body.Close()
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		var message struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			return fmt.Errorf("parse output: %w", err)
+		}
+		if message.Error != "" {
+			return fmt.Errorf("%w: %s", ErrBuild, message.Error)
+		}
+		if line := strings.TrimSpace(message.Stream); line != "" {
+			logger.Debug(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read output: %w", err)
+	}
+	return nil
+}
+
+// image name for service. Built images without explicit name are tagged as <project>_<service>.
+func (eng *engine) image(service types.ServiceConfig) string {
+	if service.Image != "" {
+		return service.Image
+	}
+	return strings.ToLower(eng.name + "_" + service.Name)
+}
+
+func (eng *engine) createNetworks(ctx context.Context) error {
+	for key, config := range eng.networks() {
+		_, err := eng.docker.NetworkInspect(ctx, config.Name, dockerTypes.NetworkInspectOptions{})
+		if err == nil {
+			continue
+		}
+		if !client.IsErrNotFound(err) {
+			return fmt.Errorf("inspect network %s: %w", config.Name, err)
+		}
+		if config.External.External {
+			return fmt.Errorf("%w: network %s", ErrExternalNotFound, config.Name)
+		}
+		labels := map[string]string{labelProject: eng.name, labelNetwork: key, "managed-by": "git-pipe"}
+		for k, v := range config.Labels {
+			labels[k] = v
+		}
+		_, err = eng.docker.NetworkCreate(ctx, config.Name, dockerTypes.NetworkCreate{
+			CheckDuplicate: true,
+			Driver:         config.Driver,
+			Options:        config.DriverOpts,
+			Internal:       config.Internal,
+			Attachable:     config.Attachable,
+			Labels:         labels,
+		})
+		if err != nil {
+			return fmt.Errorf("create network %s: %w", config.Name, err)
+		}
+	}
+	return nil
+}
+
+// networks of project including implicit default network.
+func (eng *engine) networks() map[string]types.NetworkConfig {
+	networks := make(map[string]types.NetworkConfig, len(eng.project.Networks)+1)
+	for key, config := range eng.project.Networks {
+		networks[key] = config
+	}
+	if _, ok := networks[defaultNetwork]; !ok {
+		networks[defaultNetwork] = types.NetworkConfig{Name: eng.name + "_" + defaultNetwork}
+	}
+	return networks
+}
+
+func (eng *engine) createVolumes(ctx context.Context) error {
+	for key, config := range eng.project.Volumes {
+		_, err := eng.docker.VolumeInspect(ctx, config.Name)
+		if err == nil {
+			continue
+		}
+		if !client.IsErrNotFound(err) {
+			return fmt.Errorf("inspect volume %s: %w", config.Name, err)
+		}
+		if config.External.External {
+			return fmt.Errorf("%w: volume %s", ErrExternalNotFound, config.Name)
+		}
+		labels := map[string]string{labelProject: eng.name, labelVolume: key, "managed-by": "git-pipe"}
+		for k, v := range config.Labels {
+			labels[k] = v
+		}
+		_, err = eng.docker.VolumeCreate(ctx, volume.VolumeCreateBody{
+			Name:       config.Name,
+			Driver:     config.Driver,
+			DriverOpts: config.DriverOpts,
+			Labels:     labels,
+		})
+		if err != nil {
+			return fmt.Errorf("create volume %s: %w", config.Name, err)
+		}
+	}
+	return nil
+}
+
+// removeContainers of the project: previous version and orphans.
+func (eng *engine) removeContainers(ctx context.Context) error {
+	list, err := eng.docker.ContainerList(ctx, dockerTypes.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", labelProject+"="+eng.name)),
+	})
+	if err != nil {
+		return fmt.Errorf("list containers: %w", err)
+	}
+	var all *multierror.Error
+	for _, ct := range list {
+		err := eng.docker.ContainerRemove(ctx, ct.ID, dockerTypes.ContainerRemoveOptions{Force: true})
+		if err != nil && !client.IsErrNotFound(err) {
+			all = multierror.Append(all, fmt.Errorf("remove container %s: %w", ct.ID, err))
+		}
+	}
+	return all.ErrorOrNil()
+}
+
+// start all replicas of service. Replicas are numbered from 1.
+func (eng *engine) start(ctx context.Context, service types.ServiceConfig) error {
+	count := replicas(service)
+	if count > 1 && service.ContainerName != "" {
+		return ErrContainerName
+	}
+	for number := 1; number <= count; number++ {
+		if err := eng.startReplica(ctx, service, number); err != nil {
+			return fmt.Errorf("replica %d: %w", number, err)
+		}
+	}
+	return nil
+}
+
+func (eng *engine) startReplica(ctx context.Context, service types.ServiceConfig, number int) error {
+	config, hostConfig, err := eng.containerConfig(service, number)
+	if err != nil {
+		return err
+	}
+
+	// Container is created in the first network, and then connected to others
+	networks, err := eng.serviceNetworks(service)
+	if err != nil {
+		return err
+	}
+	var networkingConfig network.NetworkingConfig
+	if service.NetworkMode == "" && len(networks) > 0 {
+		hostConfig.NetworkMode = container.NetworkMode(networks[0].name)
+		networkingConfig.EndpointsConfig = map[string]*network.EndpointSettings{
+			networks[0].name: networks[0].settings,
+		}
+	}
+
+	name := service.ContainerName
+	if name == "" {
+		name = eng.name + "_" + service.Name + "_" + strconv.Itoa(number)
+	}
+
+	res, err := eng.docker.ContainerCreate(ctx, config, hostConfig, &networkingConfig, nil, name)
+	if err != nil {
+		return fmt.Errorf("create container: %w", err)
+	}
+	eng.created = append(eng.created, res.ID)
+	eng.byName[service.Name] = append(eng.byName[service.Name], res.ID)
+
+	if service.NetworkMode == "" {
+		for _, extra := range networks[1:] {
+			if err := eng.docker.NetworkConnect(ctx, extra.name, res.ID, extra.settings); err != nil {
+				return fmt.Errorf("connect to network %s: %w", extra.name, err)
+			}
+		}
+	}
+
+	if err := eng.docker.ContainerStart(ctx, res.ID, dockerTypes.ContainerStartOptions{}); err != nil {
+		return fmt.Errorf("start container: %w", err)
+	}
+	internal.LoggerFromContext(ctx).Info("service started", zap.String("service", service.Name), zap.String("container", name))
+	return nil
+}
+
+type serviceNetwork struct {
+	name     string
+	settings *network.EndpointSettings
+}
+
+// serviceNetworks sorted by priority (higher first) and then by name. Service name is always alias.
+func (eng *engine) serviceNetworks(service types.ServiceConfig) ([]serviceNetwork, error) {
+	projectNetworks := eng.networks()
+	keys := make([]string, 0, len(service.Networks))
+	for key := range service.Networks {
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		keys = append(keys, defaultNetwork)
+	}
+	priority := func(key string) int {
+		if cfg := service.Networks[key]; cfg != nil {
+			return cfg.Priority
+		}
+		return 0
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if pi, pj := priority(keys[i]), priority(keys[j]); pi != pj {
+			return pi > pj
+		}
+		return keys[i] < keys[j]
+	})
+
+	var ans = make([]serviceNetwork, 0, len(keys))
+	for _, key := range keys {
+		config, ok := projectNetworks[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownNetwork, key)
+		}
+		settings := &network.EndpointSettings{Aliases: []string{service.Name}}
+		if cfg := service.Networks[key]; cfg != nil {
+			settings.Aliases = append(settings.Aliases, cfg.Aliases...)
+			if cfg.Ipv4Address != "" || cfg.Ipv6Address != "" {
+				settings.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: cfg.Ipv4Address, IPv6Address: cfg.Ipv6Address}
+			}
+		}
+		ans = append(ans, serviceNetwork{name: config.Name, settings: settings})
+	}
+	return ans, nil
+}
+
+func (eng *engine) containerConfig(service types.ServiceConfig, number int) (*container.Config, *container.HostConfig, error) {
+	labels := map[string]string{
+		labelProject: eng.name,
+		labelService: service.Name,
+		labelNumber:  strconv.Itoa(number),
+		labelOneOff:  "False",
+		"managed-by": "git-pipe",
+	}
+	for k, v := range service.Labels {
+		labels[k] = v
+	}
+
+	var env []string
+	for k, v := range eng.resolve(service.Environment) {
+		if v != nil {
+			env = append(env, k+"="+*v)
+		}
+	}
+	sort.Strings(env)
+
+	config := &container.Config{
+		Hostname:    service.Hostname,
+		Domainname:  service.DomainName,
+		User:        service.User,
+		Tty:         service.Tty,
+		OpenStdin:   service.StdinOpen,
+		Env:         env,
+		Cmd:         strslice.StrSlice(service.Command),
+		Entrypoint:  strslice.StrSlice(service.Entrypoint),
+		Image:       eng.image(service),
+		WorkingDir:  service.WorkingDir,
+		Labels:      labels,
+		StopSignal:  service.StopSignal,
+		Healthcheck: healthConfig(service.HealthCheck),
+	}
+	if service.StopGracePeriod != nil {
+		timeout := int(time.Duration(*service.StopGracePeriod).Seconds())
+		config.StopTimeout = &timeout
+	}
+
+	restart, err := restartPolicy(service.Restart)
+	if err != nil {
+		return nil, nil, err
+	}
+	devices, err := deviceMappings(service.Devices)
+	if err != nil {
+		return nil, nil, err
+	}
+	networkMode, err := eng.namespace(service.NetworkMode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("network_mode: %w", err)
+	}
+	ipcMode, err := eng.namespace(service.Ipc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ipc: %w", err)
+	}
+	pidMode, err := eng.namespace(service.Pid)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pid: %w", err)
+	}
+
+	hostConfig := &container.HostConfig{
+		NetworkMode:    container.NetworkMode(networkMode),
+		IpcMode:        container.IpcMode(ipcMode),
+		PidMode:        container.PidMode(pidMode),
+		RestartPolicy:  restart,
+		CapAdd:         service.CapAdd,
+		CapDrop:        service.CapDrop,
+		DNS:            service.DNS,
+		ExtraHosts:     service.ExtraHosts,
+		Privileged:     service.Privileged,
+		SecurityOpt:    service.SecurityOpt,
+		ShmSize:        int64(service.ShmSize),
+		ReadonlyRootfs: service.ReadOnly,
+		Sysctls:        service.Sysctls,
+		Init:           service.Init,
+		Mounts:         eng.mounts(service),
+		Tmpfs:          tmpfs(service.Tmpfs),
+		Resources: container.Resources{
+			Memory:   int64(service.MemLimit),
+			NanoCPUs: int64(float64(service.CPUS) * 1e9), //nolint:gomnd
+			Devices:  devices,
+			Ulimits:  ulimits(service.Ulimits),
+		},
+	}
+	if service.Logging != nil {
+		hostConfig.LogConfig = container.LogConfig{Type: service.Logging.Driver, Config: service.Logging.Options}
+	}
+	return config, hostConfig, nil
+}
+
+// namespace (network, ipc or pid mode) of container. Reference to other service (service:<name>) is replaced by
+// reference to the first container of the service, which is already started due to start order.
+func (eng *engine) namespace(mode string) (string, error) {
+	if !strings.HasPrefix(mode, types.NetworkModeServicePrefix) {
+		return mode, nil
+	}
+	name := strings.TrimPrefix(mode, types.NetworkModeServicePrefix)
+	ids := eng.byName[name]
+	if len(ids) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	return "container:" + ids[0], nil
+}
+
+func (eng *engine) mounts(service types.ServiceConfig) []mount.Mount {
+	var mounts = make([]mount.Mount, 0, len(service.Volumes))
+	for _, vol := range service.Volumes {
+		mnt := mount.Mount{
+			Type:     mount.Type(vol.Type),
+			Source:   vol.Source,
+			Target:   vol.Target,
+			ReadOnly: vol.ReadOnly,
+		}
+		switch vol.Type {
+		case types.VolumeTypeVolume:
+			if config, ok := eng.project.Volumes[vol.Source]; ok {
+				mnt.Source = config.Name
+			}
+			if vol.Volume != nil {
+				mnt.VolumeOptions = &mount.VolumeOptions{NoCopy: vol.Volume.NoCopy}
+			}
+		case types.VolumeTypeBind:
+			if vol.Bind != nil && vol.Bind.Propagation != "" {
+				mnt.BindOptions = &mount.BindOptions{Propagation: mount.Propagation(vol.Bind.Propagation)}
+			}
+		}
+		mounts = append(mounts, mnt)
+	}
+	return mounts
+}
+
+// resolve variables without value from environment. Unknown variables are kept without value.
+func (eng *engine) resolve(mapping types.MappingWithEquals) map[string]*string {
+	var ans = make(map[string]*string, len(mapping))
+	for k, v := range mapping {
+		if v == nil {
+			if value, ok := eng.vars[k]; ok {
+				v = &value
+			}
+		}
+		ans[k] = v
+	}
+	return ans
+}
+
+// startOrder of services: dependencies first.
+func startOrder(services types.Services) ([]types.ServiceConfig, error) {
+	byName := make(map[string]types.ServiceConfig, len(services))
+	names := make([]string, 0, len(services))
+	for _, service := range services {
+		byName[service.Name] = service
+		names = append(names, service.Name)
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	var (
+		state = make(map[string]int)
+		order []types.ServiceConfig
+		visit func(name string) error
+	)
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("%w: %s", ErrDependencyCycle, name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		service := byName[name]
+		for _, dependency := range dependencies(service) {
+			if _, ok := byName[dependency]; !ok {
+				continue
+			}
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		order = append(order, service)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// dependencies of service from depends_on and service references in network_mode, ipc and pid.
+func dependencies(service types.ServiceConfig) []string {
+	var names = make([]string, 0, len(service.DependsOn))
+	for dependency := range service.DependsOn {
+		names = append(names, dependency)
+	}
+	for _, mode := range []string{service.NetworkMode, service.Ipc, service.Pid} {
+		if strings.HasPrefix(mode, types.NetworkModeServicePrefix) {
+			names = append(names, strings.TrimPrefix(mode, types.NetworkModeServicePrefix))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// replicas of service from deploy.replicas or scale. Default is one.
+func replicas(service types.ServiceConfig) int {
+	if service.Deploy != nil && service.Deploy.Replicas != nil {
+		return int(*service.Deploy.Replicas)
+	}
+	if service.Scale > 0 {
+		return service.Scale
+	}
+	return 1
+}
+
+func healthConfig(check *types.HealthCheckConfig) *container.HealthConfig {
+	if check == nil {
+		return nil
+	}
+	if check.Disable {
+		return &container.HealthConfig{Test: []string{"NONE"}}
+	}
+	config := &container.HealthConfig{Test: check.Test}
+	if check.Interval != nil {
+		config.Interval = time.Duration(*check.Interval)
+	}
+	if check.Timeout != nil {
+		config.Timeout = time.Duration(*check.Timeout)
+	}
+	if check.StartPeriod != nil {
+		config.StartPeriod = time.Duration(*check.StartPeriod)
+	}
+	if check.Retries != nil {
+		config.Retries = int(*check.Retries)
+	}
+	return config
+}
+
+var errInvalidRestart = errors.New("invalid restart policy")
+
+func restartPolicy(value string) (container.RestartPolicy, error) {
+	parts := strings.SplitN(value, ":", 2) //nolint:gomnd
+	policy := container.RestartPolicy{Name: parts[0]}
+	switch policy.Name {
+	case "", "no":
+		return container.RestartPolicy{}, nil
+	case "always", "unless-stopped":
+	case "on-failure":
+		if len(parts) == 2 { //nolint:gomnd
+			count, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return policy, fmt.Errorf("%w: %s", errInvalidRestart, value)
+			}
+			policy.MaximumRetryCount = count
+		}
+	default:
+		return policy, fmt.Errorf("%w: %s", errInvalidRestart, value)
+	}
+	return policy, nil
+}
+
+var errInvalidDevice = errors.New("invalid device mapping")
+
+// deviceMappings parses devices in format host[:container[:permissions]].
+func deviceMappings(devices []string) ([]container.DeviceMapping, error) {
+	var ans = make([]container.DeviceMapping, 0, len(devices))
+	for _, device := range devices {
+		parts := strings.Split(device, ":")
+		mapping := container.DeviceMapping{PathOnHost: parts[0], PathInContainer: parts[0], CgroupPermissions: "rwm"}
+		switch len(parts) {
+		case 1:
+		case 2: //nolint:gomnd
+			mapping.PathInContainer = parts[1]
+		case 3: //nolint:gomnd
+			mapping.PathInContainer = parts[1]
+			mapping.CgroupPermissions = parts[2]
+		default:
+			return nil, fmt.Errorf("%w: %s", errInvalidDevice, device)
+		}
+		ans = append(ans, mapping)
+	}
+	return ans, nil
+}
+
+// ulimits sorted by name. Single value sets both soft and hard limits.
+func ulimits(limits map[string]*types.UlimitsConfig) []*units.Ulimit {
+	var ans = make([]*units.Ulimit, 0, len(limits))
+	for name, limit := range limits {
+		if limit == nil {
+			continue
+		}
+		soft, hard := limit.Soft, limit.Hard
+		if limit.Single != 0 {
+			soft, hard = limit.Single, limit.Single
+		}
+		ans = append(ans, &units.Ulimit{Name: name, Soft: int64(soft), Hard: int64(hard)})
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		return ans[i].Name < ans[j].Name
+	})
+	return ans
+}
+
+// tmpfs mounts in format path[:options].
+func tmpfs(list []string) map[string]string {
+	if len(list) == 0 {
+		return nil
+	}
+	var ans = make(map[string]string, len(list))
+	for _, item := range list {
+		parts := strings.SplitN(item, ":", 2) //nolint:gomnd
+		if len(parts) == 2 {                  //nolint:gomnd
+			ans[parts[0]] = parts[1]
+		} else {
+			ans[parts[0]] = ""
+		}
+	}
+	return ans
+}
+
+// readDockerIgnore returns exclude patterns from .dockerignore in the directory (if exists).
+func readDockerIgnore(dir string) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ".dockerignore"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read .dockerignore: %w", err)
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, filepath.Clean(line))
+	}
+	return patterns, nil
+}
@@ -0,0 +1,400 @@
+package compose_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/compose-spec/compose-go/types"
+	dockerTypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-units"
+	"github.com/reddec/git-pipe/packs/compose"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartOrder(t *testing.T) {
+	service := func(name string, dependencies ...string) types.ServiceConfig {
+		dependsOn := make(types.DependsOnConfig)
+		for _, dependency := range dependencies {
+			dependsOn[dependency] = types.ServiceDependency{Condition: "service_started"}
+		}
+		return types.ServiceConfig{Name: name, DependsOn: dependsOn}
+	}
+
+	cases := []struct {
+		name     string
+		services types.Services
+		order    []string
+	}{
+		{"independent services sorted by name", types.Services{service("web"), service("db"), service("cache")}, []string{"cache", "db", "web"}},
+		{"chain", types.Services{service("web", "api"), service("api", "db"), service("db")}, []string{"db", "api", "web"}},
+		{"diamond", types.Services{service("web", "api", "auth"), service("api", "db"), service("auth", "db"), service("db")}, []string{"db", "api", "auth", "web"}},
+		{"unknown dependency ignored", types.Services{service("web", "external")}, []string{"web"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			order, err := compose.StartOrder(c.services)
+			require.NoError(t, err)
+			var names []string
+			for _, s := range order {
+				names = append(names, s.Name)
+			}
+			assert.Equal(t, c.order, names)
+		})
+	}
+
+	t.Run("cycle", func(t *testing.T) {
+		_, err := compose.StartOrder(types.Services{service("web", "api"), service("api", "db"), service("db", "web")})
+		assert.ErrorIs(t, err, compose.ErrDependencyCycle)
+
+		_, err = compose.StartOrder(types.Services{service("web", "web")})
+		assert.ErrorIs(t, err, compose.ErrDependencyCycle)
+	})
+
+	t.Run("namespace of other service", func(t *testing.T) {
+		order, err := compose.StartOrder(types.Services{
+			{Name: "app", NetworkMode: "service:vpn"},
+			{Name: "debug", Pid: "service:worker"},
+			{Name: "vpn"},
+			{Name: "worker", Ipc: "service:app"},
+		})
+		require.NoError(t, err)
+		var names []string
+		for _, s := range order {
+			names = append(names, s.Name)
+		}
+		assert.Equal(t, []string{"vpn", "app", "worker", "debug"}, names)
+	})
+}
+
+func TestReplicas(t *testing.T) {
+	two, zero := uint64(2), uint64(0)
+	assert.Equal(t, 1, compose.Replicas(types.ServiceConfig{}), "one replica by default")
+	assert.Equal(t, 2, compose.Replicas(types.ServiceConfig{Deploy: &types.DeployConfig{Replicas: &two}}))
+	assert.Equal(t, 0, compose.Replicas(types.ServiceConfig{Deploy: &types.DeployConfig{Replicas: &zero}}), "service could be disabled")
+	assert.Equal(t, 3, compose.Replicas(types.ServiceConfig{Scale: 3}))
+	assert.Equal(t, 2, compose.Replicas(types.ServiceConfig{Scale: 3, Deploy: &types.DeployConfig{Replicas: &two}}), "deploy has priority over scale")
+	assert.Equal(t, 1, compose.Replicas(types.ServiceConfig{Deploy: &types.DeployConfig{}}))
+}
+
+func TestStart_containerName(t *testing.T) {
+	eng := compose.NewEngine(nil, "app", &types.Project{Name: "app"}, nil)
+	err := compose.Start(eng, context.Background(), types.ServiceConfig{Name: "web", ContainerName: "web", Scale: 2})
+	assert.ErrorIs(t, err, compose.ErrContainerName)
+}
+
+func TestRestartPolicy(t *testing.T) {
+	cases := []struct {
+		value  string
+		policy container.RestartPolicy
+		valid  bool
+	}{
+		{"", container.RestartPolicy{}, true},
+		{"no", container.RestartPolicy{}, true},
+		{"always", container.RestartPolicy{Name: "always"}, true},
+		{"unless-stopped", container.RestartPolicy{Name: "unless-stopped"}, true},
+		{"on-failure", container.RestartPolicy{Name: "on-failure"}, true},
+		{"on-failure:3", container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}, true},
+		{"on-failure:many", container.RestartPolicy{}, false},
+		{"sometimes", container.RestartPolicy{}, false},
+	}
+	for _, c := range cases {
+		policy, err := compose.RestartPolicy(c.value)
+		if !c.valid {
+			assert.Error(t, err, c.value)
+			continue
+		}
+		require.NoError(t, err, c.value)
+		assert.Equal(t, c.policy, policy, c.value)
+	}
+}
+
+func TestDeviceMappings(t *testing.T) {
+	mappings, err := compose.DeviceMappings([]string{"/dev/fuse", "/dev/ttyUSB0:/dev/serial", "/dev/sda:/dev/xvda:r"})
+	require.NoError(t, err)
+	assert.Equal(t, []container.DeviceMapping{
+		{PathOnHost: "/dev/fuse", PathInContainer: "/dev/fuse", CgroupPermissions: "rwm"},
+		{PathOnHost: "/dev/ttyUSB0", PathInContainer: "/dev/serial", CgroupPermissions: "rwm"},
+		{PathOnHost: "/dev/sda", PathInContainer: "/dev/xvda", CgroupPermissions: "r"},
+	}, mappings)
+
+	_, err = compose.DeviceMappings([]string{"/dev/a:/dev/b:rw:extra"})
+	assert.Error(t, err)
+}
+
+func TestTmpfs(t *testing.T) {
+	assert.Nil(t, compose.Tmpfs(nil))
+	assert.Equal(t, map[string]string{"/run": "", "/tmp": "size=64m,mode=1777"}, compose.Tmpfs([]string{"/run", "/tmp:size=64m,mode=1777"}))
+}
+
+func TestHealthConfig(t *testing.T) {
+	assert.Nil(t, compose.HealthConfig(nil))
+	assert.Equal(t, &container.HealthConfig{Test: []string{"NONE"}}, compose.HealthConfig(&types.HealthCheckConfig{Disable: true}))
+
+	interval, timeout, start := types.Duration(10*time.Second), types.Duration(3*time.Second), types.Duration(time.Minute)
+	retries := uint64(5)
+	assert.Equal(t, &container.HealthConfig{
+		Test:        []string{"CMD", "curl", "-f", "http://localhost"},
+		Interval:    10 * time.Second,
+		Timeout:     3 * time.Second,
+		StartPeriod: time.Minute,
+		Retries:     5,
+	}, compose.HealthConfig(&types.HealthCheckConfig{
+		Test:        []string{"CMD", "curl", "-f", "http://localhost"},
+		Interval:    &interval,
+		Timeout:     &timeout,
+		StartPeriod: &start,
+		Retries:     &retries,
+	}))
+}
+
+func TestReadDockerIgnore(t *testing.T) {
+	dir := t.TempDir()
+	patterns, err := compose.ReadDockerIgnore(dir)
+	require.NoError(t, err)
+	assert.Empty(t, patterns)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("# comment\n\n./node_modules\n  .git  \nbuild/\n"), 0600))
+	patterns, err = compose.ReadDockerIgnore(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node_modules", ".git", "build"}, patterns)
+}
+
+func TestContainerConfig(t *testing.T) {
+	project := &types.Project{
+		Name:    "app",
+		Volumes: types.Volumes{"data": types.VolumeConfig{Name: "app_data"}},
+	}
+	eng := compose.NewEngine(nil, "app", project, map[string]string{"TOKEN": "secret"})
+
+	grace := types.Duration(30 * time.Second)
+	service := types.ServiceConfig{
+		Name:            "web",
+		Build:           &types.BuildConfig{Context: "."},
+		Command:         []string{"serve", "--port", "80"},
+		Environment:     types.MappingWithEquals{"MODE": strPtr("production"), "TOKEN": nil, "UNKNOWN": nil},
+		Labels:          types.Labels{"team": "web"},
+		Restart:         "on-failure:2",
+		StopGracePeriod: &grace,
+		Devices:         []string{"/dev/fuse"},
+		Tmpfs:           []string{"/run"},
+		MemLimit:        64 * 1024 * 1024,
+		CPUS:            0.5,
+		Volumes: []types.ServiceVolumeConfig{
+			{Type: types.VolumeTypeVolume, Source: "data", Target: "/data"},
+			{Type: types.VolumeTypeBind, Source: "/etc/app", Target: "/etc/app", ReadOnly: true, Bind: &types.ServiceVolumeBind{Propagation: "rprivate"}},
+		},
+	}
+
+	config, hostConfig, err := compose.ContainerConfig(eng, service, 2)
+	require.NoError(t, err)
+
+	assert.Equal(t, "app_web", config.Image, "built image is named by project and service")
+	assert.Equal(t, []string{"serve", "--port", "80"}, []string(config.Cmd))
+	assert.Equal(t, []string{"MODE=production", "TOKEN=secret"}, config.Env, "variables without value are resolved, unknown are skipped")
+	assert.Equal(t, map[string]string{
+		"com.docker.compose.project":          "app",
+		"com.docker.compose.service":          "web",
+		"com.docker.compose.container-number": "2",
+		"com.docker.compose.oneoff":           "False",
+		"managed-by":                          "git-pipe",
+		"team":                                "web",
+	}, config.Labels)
+	require.NotNil(t, config.StopTimeout)
+	assert.Equal(t, 30, *config.StopTimeout)
+
+	assert.Equal(t, container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 2}, hostConfig.RestartPolicy)
+	assert.Equal(t, int64(64*1024*1024), hostConfig.Memory)
+	assert.Equal(t, int64(500000000), hostConfig.NanoCPUs)
+	assert.Equal(t, []container.DeviceMapping{{PathOnHost: "/dev/fuse", PathInContainer: "/dev/fuse", CgroupPermissions: "rwm"}}, hostConfig.Devices)
+	assert.Equal(t, map[string]string{"/run": ""}, hostConfig.Tmpfs)
+	assert.Equal(t, []mount.Mount{
+		{Type: mount.TypeVolume, Source: "app_data", Target: "/data"},
+		{Type: mount.TypeBind, Source: "/etc/app", Target: "/etc/app", ReadOnly: true, BindOptions: &mount.BindOptions{Propagation: mount.PropagationRPrivate}},
+	}, hostConfig.Mounts)
+
+	service.Restart = "sometimes"
+	_, _, err = compose.ContainerConfig(eng, service, 1)
+	assert.Error(t, err)
+}
+
+func TestContainerConfig_hostOptions(t *testing.T) {
+	eng := compose.NewEngine(nil, "app", &types.Project{Name: "app"}, nil)
+	eng.AddContainers("vpn", "vpn-id")
+	eng.AddContainers("worker", "worker-1", "worker-2")
+
+	service := types.ServiceConfig{
+		Name:        "web",
+		Image:       "nginx",
+		NetworkMode: "service:vpn",
+		Ipc:         "service:worker",
+		Pid:         "host",
+		SecurityOpt: []string{"no-new-privileges:true"},
+		ShmSize:     128 * 1024 * 1024,
+		Ulimits: map[string]*types.UlimitsConfig{
+			"nproc":  {Single: 65535},
+			"nofile": {Soft: 1024, Hard: 4096},
+		},
+		Logging: &types.LoggingConfig{Driver: "json-file", Options: map[string]string{"max-size": "10m"}},
+	}
+
+	_, hostConfig, err := compose.ContainerConfig(eng, service, 1)
+	require.NoError(t, err)
+	assert.Equal(t, container.NetworkMode("container:vpn-id"), hostConfig.NetworkMode)
+	assert.Equal(t, container.IpcMode("container:worker-1"), hostConfig.IpcMode, "first container of service is used")
+	assert.Equal(t, container.PidMode("host"), hostConfig.PidMode)
+	assert.Equal(t, []string{"no-new-privileges:true"}, hostConfig.SecurityOpt)
+	assert.Equal(t, int64(128*1024*1024), hostConfig.ShmSize)
+	assert.Equal(t, []*units.Ulimit{
+		{Name: "nofile", Soft: 1024, Hard: 4096},
+		{Name: "nproc", Soft: 65535, Hard: 65535},
+	}, hostConfig.Ulimits)
+	assert.Equal(t, container.LogConfig{Type: "json-file", Config: map[string]string{"max-size": "10m"}}, hostConfig.LogConfig)
+
+	service.Pid = "service:unknown"
+	_, _, err = compose.ContainerConfig(eng, service, 1)
+	assert.ErrorIs(t, err, compose.ErrServiceNotFound)
+	assert.Contains(t, err.Error(), "pid")
+}
+
+func TestBuild_options(t *testing.T) {
+	docker := &fakeBuilder{}
+	eng := compose.NewEngine(docker, "app", &types.Project{Name: "app"}, nil)
+	service := types.ServiceConfig{
+		Name: "web",
+		Build: &types.BuildConfig{
+			Context:    t.TempDir(),
+			CacheFrom:  types.StringList{"registry.example.com/app/web:latest"},
+			ExtraHosts: types.HostsList{"registry.local:10.0.0.1"},
+			Network:    "host",
+		},
+	}
+
+	require.NoError(t, compose.BuildImage(eng, context.Background(), service))
+	assert.Equal(t, []string{"app_web"}, docker.options.Tags)
+	assert.Equal(t, []string{"registry.example.com/app/web:latest"}, docker.options.CacheFrom)
+	assert.Equal(t, []string{"registry.local:10.0.0.1"}, docker.options.ExtraHosts)
+	assert.Equal(t, "host", docker.options.NetworkMode)
+}
+
+// fakeBuilder records build options and reports successful build.
+type fakeBuilder struct {
+	client.APIClient
+	options dockerTypes.ImageBuildOptions
+}
+
+func (fb *fakeBuilder) ImageBuild(_ context.Context, _ io.Reader, options dockerTypes.ImageBuildOptions) (dockerTypes.ImageBuildResponse, error) {
+	fb.options = options
+	return dockerTypes.ImageBuildResponse{Body: ioutil.NopCloser(strings.NewReader(`{"stream": "done"}`))}, nil
+}
+
+func strPtr(value string) *string {
+	return &value
+}
+
+func TestWaitDependencies(t *testing.T) {
+	var (
+		starting  = dockerTypes.ContainerState{Running: true, Health: &dockerTypes.Health{Status: dockerTypes.Starting}}
+		healthy   = dockerTypes.ContainerState{Running: true, Health: &dockerTypes.Health{Status: dockerTypes.Healthy}}
+		unhealthy = dockerTypes.ContainerState{Running: true, Health: &dockerTypes.Health{Status: dockerTypes.Unhealthy}}
+		crashed   = dockerTypes.ContainerState{ExitCode: 1, Health: &dockerTypes.Health{Status: dockerTypes.Starting}}
+		running   = dockerTypes.ContainerState{Running: true}
+	)
+	web := func(condition string) types.ServiceConfig {
+		return types.ServiceConfig{Name: "web", DependsOn: types.DependsOnConfig{"db": {Condition: condition}}}
+	}
+
+	cases := []struct {
+		name      string
+		condition string
+		states    []dockerTypes.ContainerState
+		exit      *int64 // exit code, nil means container never stops
+		err       error
+	}{
+		{name: "healthy", condition: "service_healthy", states: []dockerTypes.ContainerState{starting, healthy}},
+		{name: "unhealthy", condition: "service_healthy", states: []dockerTypes.ContainerState{starting, unhealthy}, err: compose.ErrUnhealthy},
+		{name: "exited before healthy", condition: "service_healthy", states: []dockerTypes.ContainerState{crashed}, err: compose.ErrExited},
+		{name: "no healthcheck", condition: "service_healthy", states: []dockerTypes.ContainerState{running}, err: compose.ErrNoHealthcheck},
+		{name: "healthy timeout", condition: "service_healthy", states: []dockerTypes.ContainerState{starting}, err: context.DeadlineExceeded},
+		{name: "completed", condition: "service_completed_successfully", exit: exitCode(0)},
+		{name: "completed with error", condition: "service_completed_successfully", exit: exitCode(2), err: compose.ErrExited},
+		{name: "completed timeout", condition: "service_completed_successfully", err: context.DeadlineExceeded},
+		{name: "started", condition: "service_started"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			docker := &fakeDocker{states: map[string][]dockerTypes.ContainerState{"db-1": c.states}, exits: map[string]int64{}}
+			if c.exit != nil {
+				docker.exits["db-1"] = *c.exit
+			}
+			eng := compose.NewEngine(docker, "app", &types.Project{Name: "app"}, nil)
+			eng.AddContainers("db", "db-1")
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			err := compose.WaitDependencies(eng, ctx, web(c.condition))
+			if c.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, c.err)
+			}
+		})
+	}
+}
+
+func TestEngine_WaitHealthy(t *testing.T) {
+	docker := &fakeDocker{states: map[string][]dockerTypes.ContainerState{
+		"web-1": {{Running: true}},
+		"db-1":  {{Running: true, Health: &dockerTypes.Health{Status: dockerTypes.Healthy}}},
+	}}
+	eng := compose.NewEngine(docker, "app", &types.Project{Name: "app"}, nil)
+	eng.AddContainers("web", "web-1")
+	eng.AddContainers("db", "db-1")
+	assert.NoError(t, eng.WaitHealthy(context.Background()), "containers without health check are ignored")
+
+	docker.states["db-1"] = []dockerTypes.ContainerState{{Running: true, Health: &dockerTypes.Health{Status: dockerTypes.Unhealthy}}}
+	assert.ErrorIs(t, eng.WaitHealthy(context.Background()), compose.ErrUnhealthy)
+}
+
+func exitCode(code int64) *int64 {
+	return &code
+}
+
+// fakeDocker reports container states in sequence (the last state is kept) and exit codes of containers.
+// Other methods are not implemented.
+type fakeDocker struct {
+	client.APIClient
+	lock   sync.Mutex
+	states map[string][]dockerTypes.ContainerState
+	exits  map[string]int64
+}
+
+func (fd *fakeDocker) ContainerInspect(_ context.Context, containerID string) (dockerTypes.ContainerJSON, error) {
+	fd.lock.Lock()
+	defer fd.lock.Unlock()
+	states := fd.states[containerID]
+	state := states[0]
+	if len(states) > 1 {
+		fd.states[containerID] = states[1:]
+	}
+	return dockerTypes.ContainerJSON{ContainerJSONBase: &dockerTypes.ContainerJSONBase{Name: "/" + containerID, State: &state}}, nil
+}
+
+func (fd *fakeDocker) ContainerWait(_ context.Context, containerID string, _ container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error) {
+	fd.lock.Lock()
+	defer fd.lock.Unlock()
+	okC := make(chan container.ContainerWaitOKBody, 1)
+	if code, ok := fd.exits[containerID]; ok {
+		okC <- container.ContainerWaitOKBody{StatusCode: code}
+	}
+	return okC, make(chan error)
+}
@@ -0,0 +1,23 @@
+package compose
+
+//nolint:gochecknoglobals
+var (
+	NewEngine        = newEngine
+	StartOrder       = startOrder
+	Replicas         = replicas
+	RestartPolicy    = restartPolicy
+	DeviceMappings   = deviceMappings
+	Tmpfs            = tmpfs
+	HealthConfig     = healthConfig
+	ReadDockerIgnore = readDockerIgnore
+	ContainerConfig  = (*engine).containerConfig
+	Start            = (*engine).start
+	BuildImage       = (*engine).build
+	WaitDependencies = (*engine).waitDependencies
+)
+
+// AddContainers registers containers of service as started by the engine.
+func (eng *engine) AddContainers(service string, ids ...string) {
+	eng.created = append(eng.created, ids...)
+	eng.byName[service] = append(eng.byName[service], ids...)
+}
@@ -4,7 +4,11 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/docker/docker/api/types"
@@ -21,30 +25,57 @@ import (
 	"go.uber.org/zap"
 )
 
+// Name of the pack.
+const Name = "docker"
+
+// Pack for Dockerfile or prebuilt image.
+type Pack struct{}
+
+// Detect Dockerfile or image file.
+func (Pack) Detect(directory string) bool {
+	return packs.HasAnyFile(directory, "Dockerfile", core.ImageFile)
+}
+
+func (Pack) Run(ctx context.Context, env *core.Environment) error {
+	return Run(ctx, env)
+}
+
+// suffix of volume name for mount points excluded from backup.
+const notBackedUpSuffix = "_nobackup"
+
 func Run(ctx context.Context, env *core.Environment) error {
 	logger := internal.SubLogger(ctx, "docker")
 	ctx = internal.WithLogger(ctx, logger)
 
-	// Remove old containers if possible
-	logger.Debug("cleaning old containers")
-	if err := cleanupContainers(ctx, env.Docker, env.Name); err != nil {
-		return fmt.Errorf("cleanup: %w", err)
+	if err := packs.CheckEnv(env); err != nil {
+		return err
 	}
 
-	// Build image from source
-	logger.Debug("building image")
-	image, err := buildImage(ctx, env.Docker, env.Directory)
+	// Remove old containers if possible. During takeover old containers are owned by the running previous version.
+	if !env.Handover.Takeover() {
+		logger.Debug("cleaning old containers")
+		if err := cleanupContainers(ctx, env.Docker, env.Name); err != nil {
+			return fmt.Errorf("cleanup: %w", err)
+		}
+	}
+
+	// Build image from source or use prebuilt image
+	image, err := prepareImage(ctx, env.Docker, env.Directory)
 	if err != nil {
-		return fmt.Errorf("build image: %w", err)
+		return err
 	}
 
-	// We are storing all mount points in a single volume with name equal to repo
+	// We are storing all mount points in a single volume with name equal to repo. Mount points excluded from backup
+	// by manifest are stored in a separate volume.
 	var volumes = []string{env.Name}
+	var mounts = mountPoints(image, env.Manifest.Backup, env.Name, env.Name+notBackedUpSuffix)
 
-	// Restore content in volumes
-	logger.Info("restoring volumes", zap.Strings("volumes", volumes))
-	if err := env.Backup.Restore(ctx, env.Name, volumes); err != nil {
-		return fmt.Errorf("restore: %w", err)
+	// Restore content in volumes. During takeover volumes are already in use by the previous version.
+	if !env.Handover.Takeover() {
+		logger.Info("restoring volumes", zap.Strings("volumes", volumes))
+		if err := env.Backup.Restore(ctx, env.Name, volumes); err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
 	}
 
 	// Schedule backup
@@ -54,11 +85,11 @@ func Run(ctx context.Context, env *core.Environment) error {
 
 	// Create container
 	logger.Info("creating container")
-	containerID, err := createContainer(ctx, env.Docker, image, volumes[0], env.Name, env.Vars)
+	containerID, err := createContainer(ctx, env.Docker, image, mounts, packs.Limits(env), env.Name, env.Vars)
 	if err != nil {
 		return fmt.Errorf("create container: %w", err)
 	}
-	defer cleanupContainers(context.Background(), env.Docker, env.Name)
+	defer removeContainer(context.Background(), env.Docker, containerID)
 
 	// Attach container to network
 	logger.Debug("joining network")
@@ -86,13 +117,31 @@ func Run(ctx context.Context, env *core.Environment) error {
 		}
 	}
 
-	// Register in the ingress
-	addressesByDomains := exposedServices(image, env.Name, link)
+	addressesByDomains := exposedServices(image, env.Name, link, packs.RootPortsPriority(env))
+
+	// Wait for health check from manifest
+	if err := packs.WaitHealthy(ctx, env, addressesByDomains[env.Name]); err != nil {
+		return err
+	}
+
+	if err := packs.BeforeReady(ctx, env, containerID); err != nil {
+		return err
+	}
+
+	// Forward UDP ports: they can not be routed by HTTP router
+	forwards := forwardedPorts(image, link)
+	if err := packs.SetForwards(ctx, env, forwards); err != nil {
+		return err
+	}
+	defer packs.ClearForwards(env)
+
+	// Register in the ingress. It atomically switches traffic from the previous version (if any).
+	addressesByDomains = packs.WithAliases(env, addressesByDomains)
 	logger.Debug("register ingress", zap.Int("endpoints_num", len(addressesByDomains)))
 	if err := env.Ingress.Set(ctx, env.Name, addressesByDomains); err != nil {
 		return fmt.Errorf("set ingress: %w", err)
 	}
-	defer env.Ingress.Clear(context.Background(), env.Name)
+	defer packs.ClearIngress(env)
 
 	// Register DNS
 	var domains = make([]string, 0, len(addressesByDomains))
@@ -104,9 +153,13 @@ func Run(ctx context.Context, env *core.Environment) error {
 		return fmt.Errorf("register DNS: %w", err)
 	}
 
+	// Routes could be restored by the next version if it fails
+	env.Handover.Publish(core.Routes{Domains: addressesByDomains, Forwards: forwards})
+
 	// Notify that everything is ready
 	logger.Info("ready")
 	env.Event.Ready()
+	packs.AfterReady(ctx, env, containerID)
 
 	// Wait
 	<-ctx.Done()
@@ -128,7 +181,7 @@ func startContainer(ctx context.Context, api client.APIClient, containerID strin
 	return &info, nil
 }
 
-func exposedServices(image types.ImageInspect, name string, link string) map[string][]string {
+func exposedServices(image types.ImageInspect, name string, link string, portsPriority []int) map[string][]string {
 	addressesByDomain := make(map[string][]string)
 	domainByPort := make(map[int]string)
 	// general services mapped by port: <port>.<name>
@@ -143,7 +196,7 @@ func exposedServices(image types.ImageInspect, name string, link string) map[str
 	}
 
 	// get root domain by port priority
-	for _, port := range packs.PortsPriority() {
+	for _, port := range portsPriority {
 		if domain, ok := domainByPort[port]; ok {
 			addressesByDomain[name] = addressesByDomain[domain]
 			break
@@ -160,16 +213,40 @@ func exposedServices(image types.ImageInspect, name string, link string) map[str
 	return addressesByDomain
 }
 
-func createContainer(ctx context.Context, cli client.APIClient, image types.ImageInspect, volumeName, label string, env map[string]string) (string, error) {
+// forwardedPorts are UDP ports exposed by image. Host ports are allocated by forwarder.
+func forwardedPorts(image types.ImageInspect, link string) []core.Forward {
+	var forwards []core.Forward
+	for port := range image.Config.ExposedPorts {
+		if port.Proto() != "udp" {
+			continue
+		}
+		forwards = append(forwards, core.Forward{
+			Name:      string(port),
+			Protocol:  port.Proto(),
+			Addresses: []string{link + ":" + port.Port()},
+		})
+	}
+	return forwards
+}
+
+// mountPoints for image volumes. Paths excluded from backup are mounted from separate volume.
+func mountPoints(image types.ImageInspect, backup core.Backup, volumeName, notBackedUpVolume string) []mount.Mount {
 	var mountPoints = make([]mount.Mount, 0, len(image.Config.Volumes))
 	for pathInContainer := range image.Config.Volumes {
+		source := volumeName
+		if !backup.BackedUp(pathInContainer) {
+			source = notBackedUpVolume
+		}
 		mountPoints = append(mountPoints, mount.Mount{
 			Type:   mount.TypeVolume,
-			Source: volumeName,
+			Source: source,
 			Target: pathInContainer,
 		})
 	}
+	return mountPoints
+}
 
+func createContainer(ctx context.Context, cli client.APIClient, image types.ImageInspect, mountPoints []mount.Mount, limits container.Resources, label string, env map[string]string) (string, error) {
 	res, err := cli.ContainerCreate(ctx, &container.Config{
 		Image: image.ID,
 		Env:   toEnvList(env),
@@ -181,7 +258,8 @@ func createContainer(ctx context.Context, cli client.APIClient, image types.Imag
 		RestartPolicy: container.RestartPolicy{
 			Name: "on-failure",
 		},
-		Mounts: mountPoints,
+		Mounts:    mountPoints,
+		Resources: limits,
 	}, &network.NetworkingConfig{}, nil, "")
 
 	if err != nil {
@@ -191,6 +269,29 @@ func createContainer(ctx context.Context, cli client.APIClient, image types.Imag
 	return res.ID, nil
 }
 
+// prepareImage uses image from image file if it exists, otherwise builds image from Dockerfile.
+func prepareImage(ctx context.Context, cli client.APIClient, directory string) (types.ImageInspect, error) {
+	logger := internal.LoggerFromContext(ctx)
+	ref, err := ioutil.ReadFile(filepath.Join(directory, core.ImageFile))
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Debug("building image")
+		image, err := buildImage(ctx, cli, directory)
+		if err != nil {
+			return image, fmt.Errorf("build image: %w", err)
+		}
+		return image, nil
+	}
+	if err != nil {
+		return types.ImageInspect{}, fmt.Errorf("read image file: %w", err)
+	}
+	logger.Debug("using prebuilt image", zap.String("image", strings.TrimSpace(string(ref))))
+	image, err := packs.GetImage(ctx, cli, strings.TrimSpace(string(ref)))
+	if err != nil {
+		return image, fmt.Errorf("get image: %w", err)
+	}
+	return image, nil
+}
+
 func buildImage(ctx context.Context, cli client.APIClient, directory string) (types.ImageInspect, error) {
 	tar, err := archive.TarWithOptions(directory, &archive.TarOptions{})
 	if err != nil {
@@ -256,6 +357,16 @@ func cleanupContainers(ctx context.Context, cli client.APIClient, label string)
 	return all.ErrorOrNil()
 }
 
+func removeContainer(ctx context.Context, cli client.APIClient, containerID string) error {
+	err := cli.ContainerRemove(ctx, containerID, types.ContainerRemoveOptions{
+		Force: true,
+	})
+	if err != nil {
+		return fmt.Errorf("remove container %s: %w", containerID, err)
+	}
+	return nil
+}
+
 func toEnvList(env map[string]string) []string {
 	var ans = make([]string, 0, len(env))
 	for k, v := range env {
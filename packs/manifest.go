@@ -0,0 +1,194 @@
+package packs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/reddec/git-pipe/core"
+	"github.com/reddec/git-pipe/internal"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrMissingEnv       = errors.New("required environment variables not set")
+	ErrDomainNotAllowed = errors.New("domain not allowed for the repo")
+	ErrUnhealthy        = errors.New("health check failed")
+	ErrHookFailed       = errors.New("hook failed")
+	errNoRootTarget     = errors.New("root service not exposed")
+)
+
+const (
+	defaultHealthInterval = 3 * time.Second
+	defaultHealthTimeout  = time.Minute
+)
+
+// CheckEnv returns error if any environment variable required by manifest is not set or if manifest claims domain
+// which is not allowed for the repo (see AllowedDomain).
+func CheckEnv(env *core.Environment) error {
+	if missing := env.Manifest.MissingEnv(env.Vars); len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingEnv, strings.Join(missing, ", "))
+	}
+	for _, domain := range env.Manifest.Domains {
+		if !AllowedDomain(env, domain) {
+			return fmt.Errorf("%w: %s", ErrDomainNotAllowed, domain)
+		}
+	}
+	return nil
+}
+
+// AllowedDomain checks that repo can claim domain from manifest: manifest is controlled by the repo, so it is limited
+// to aliases configured by operator and to sub-domains of the repo name.
+func AllowedDomain(env *core.Environment, domain string) bool {
+	for _, alias := range env.Aliases {
+		if alias == domain {
+			return true
+		}
+	}
+	return strings.HasSuffix(domain, "."+env.Name)
+}
+
+// Limits for container by manifest resources.
+func Limits(env *core.Environment) container.Resources {
+	const nanoCPUs = 1e9
+	return container.Resources{
+		Memory:   int64(env.Manifest.Resources.Memory),
+		NanoCPUs: int64(env.Manifest.Resources.CPUs * nanoCPUs),
+	}
+}
+
+// WaitHealthy waits till all root addresses (host:port) respond by 2xx or 3xx status on health check path
+// from manifest. Does nothing if check is not defined.
+func WaitHealthy(ctx context.Context, env *core.Environment, addresses []string) error {
+	check := env.Manifest.Healthcheck
+	if check.Path == "" {
+		return nil
+	}
+	if len(addresses) == 0 {
+		return fmt.Errorf("%w: %v", ErrUnhealthy, errNoRootTarget)
+	}
+	interval, timeout := check.Interval, check.Timeout
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for _, address := range addresses {
+		endpoint, err := env.Network.Resolve(ctx, address)
+		if err != nil {
+			return fmt.Errorf("resolve %s: %w", address, err)
+		}
+		if err := waitHTTP(ctx, "http://"+endpoint+check.Path, interval); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrUnhealthy, address, err)
+		}
+	}
+	return nil
+}
+
+func waitHTTP(ctx context.Context, url string, interval time.Duration) error {
+	logger := internal.LoggerFromContext(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := probe(ctx, url)
+		if err == nil {
+			return nil
+		}
+		logger.Debug("health check not passed", zap.String("url", url), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+func probe(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	// redirects are not followed: they are usually pointing to public domain
+	res, err := (&http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}).Do(req)
+	if err != nil {
+		return fmt.Errorf("request: %w", err)
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	_ = res.Body.Close()
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("status %d", res.StatusCode) //nolint:goerr113
+	}
+	return nil
+}
+
+// BeforeReady executes manifest hooks in the container before traffic switch. Stops on the first failed hook.
+func BeforeReady(ctx context.Context, env *core.Environment, containerID string) error {
+	for _, command := range env.Manifest.Hooks.BeforeReady {
+		if err := Exec(ctx, env.Docker, containerID, command); err != nil {
+			return fmt.Errorf("before ready hook %q: %w", command, err)
+		}
+	}
+	return nil
+}
+
+// AfterReady executes manifest hooks in the container after traffic switch. Failures are only logged.
+func AfterReady(ctx context.Context, env *core.Environment, containerID string) {
+	logger := internal.LoggerFromContext(ctx)
+	for _, command := range env.Manifest.Hooks.AfterReady {
+		if err := Exec(ctx, env.Docker, containerID, command); err != nil {
+			logger.Warn("after ready hook failed", zap.String("command", command), zap.Error(err))
+		}
+	}
+}
+
+// Exec shell command in running container. Output is streamed to the logger.
+func Exec(ctx context.Context, cli client.APIClient, containerID string, command string) error {
+	logger := internal.LoggerFromContext(ctx)
+	logger.Info("executing hook", zap.String("command", command))
+
+	exec, err := cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          []string{"sh", "-c", command},
+	})
+	if err != nil {
+		return fmt.Errorf("create exec: %w", err)
+	}
+
+	stream, err := cli.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return fmt.Errorf("attach exec: %w", err)
+	}
+	defer stream.Close()
+
+	output := internal.StreamingLogger(logger)
+	_, err = stdcopy.StdCopy(output, output, stream.Reader)
+	_ = output.Close()
+	if err != nil {
+		return fmt.Errorf("read output: %w", err)
+	}
+
+	info, err := cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return fmt.Errorf("inspect exec: %w", err)
+	}
+	if info.ExitCode != 0 {
+		return fmt.Errorf("%w: exit code %d", ErrHookFailed, info.ExitCode)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package packs_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reddec/git-pipe/core"
+	"github.com/reddec/git-pipe/packs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hostNetwork resolves addresses as is.
+type hostNetwork struct{}
+
+func (hostNetwork) Join(context.Context, string) (string, error) { return "127.0.0.1", nil }
+func (hostNetwork) Leave(context.Context, string) error          { return nil }
+func (hostNetwork) ID() string                                   { return "host" }
+func (hostNetwork) Resolve(_ context.Context, address string) (string, error) {
+	return address, nil
+}
+
+func TestWaitHealthy(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		attempts++
+		if request.URL.Path != "/health" || attempts < 3 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	env := &core.Environment{Base: core.Base{Network: hostNetwork{}}}
+	require.NoError(t, packs.WaitHealthy(context.Background(), env, nil), "check is disabled by default")
+
+	env.Manifest.Healthcheck = core.Healthcheck{Path: "/health", Interval: 10 * time.Millisecond, Timeout: time.Second}
+	require.NoError(t, packs.WaitHealthy(context.Background(), env, []string{address}))
+	assert.Equal(t, 3, attempts)
+
+	env.Manifest.Healthcheck.Path = "/missing"
+	env.Manifest.Healthcheck.Timeout = 100 * time.Millisecond
+	assert.ErrorIs(t, packs.WaitHealthy(context.Background(), env, []string{address}), packs.ErrUnhealthy)
+	assert.ErrorIs(t, packs.WaitHealthy(context.Background(), env, nil), packs.ErrUnhealthy)
+}
+
+func TestWithAliases(t *testing.T) {
+	env := &core.Environment{Name: "app", Aliases: []string{"app.example.com", "example.com"}}
+	env.Manifest.Domains = []string{"example.com", "www.app", "other"}
+	routes := packs.WithAliases(env, map[string][]string{"app": {"10.0.0.2:80"}})
+	assert.Equal(t, []string{"10.0.0.2:80"}, routes["app.example.com"])
+	assert.Equal(t, []string{"10.0.0.2:80"}, routes["example.com"])
+	assert.Equal(t, []string{"10.0.0.2:80"}, routes["www.app"])
+	assert.NotContains(t, routes, "other", "manifest can not claim domains of other repos")
+}
+
+func TestAllowedDomain(t *testing.T) {
+	env := &core.Environment{Name: "app", Aliases: []string{"example.com"}}
+	for domain, allowed := range map[string]bool{
+		"example.com":     true,  // operator alias
+		"www.app":         true,  // sub-domain of the repo
+		"api.www.app":     true,  // nested sub-domain
+		"app":             false, // root domain is added by pack
+		"other":           false, // name of other repo
+		"webapp":          false, // suffix is not sub-domain
+		"www.example.com": false, // sub-domain of alias
+	} {
+		assert.Equal(t, allowed, packs.AllowedDomain(env, domain), domain)
+	}
+
+	env.Manifest.Domains = []string{"www.app", "other"}
+	err := packs.CheckEnv(env)
+	assert.ErrorIs(t, err, packs.ErrDomainNotAllowed)
+	assert.Contains(t, err.Error(), "other")
+}
+
+func TestCheckEnv(t *testing.T) {
+	env := &core.Environment{Vars: map[string]string{"DB_URL": "postgres://"}}
+	env.Manifest.RequiredEnv = []string{"DB_URL", "SECRET"}
+	err := packs.CheckEnv(env)
+	assert.ErrorIs(t, err, packs.ErrMissingEnv)
+	assert.Contains(t, err.Error(), "SECRET")
+}
@@ -0,0 +1,92 @@
+package packs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/reddec/git-pipe/core"
+)
+
+var (
+	ErrUnknownPack  = errors.New("unknown pack")
+	ErrNotDetected  = errors.New("unknown packaging for repo")
+	ErrPackRequired = errors.New("pack name and implementation required")
+)
+
+// NewRegistry of packs. Packs are detected in order of registration.
+func NewRegistry() *Registry {
+	return &Registry{packs: make(map[string]core.Pack)}
+}
+
+// Registry of pack types. Safe for concurrent use.
+type Registry struct {
+	lock  sync.RWMutex
+	names []string
+	packs map[string]core.Pack
+}
+
+// Register pack by name. Pack with the same name will be replaced, keeping detection order.
+func (reg *Registry) Register(name string, pack core.Pack) error {
+	if name == "" || pack == nil {
+		return ErrPackRequired
+	}
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	if _, exists := reg.packs[name]; !exists {
+		reg.names = append(reg.names, name)
+	}
+	reg.packs[name] = pack
+	return nil
+}
+
+// Names of registered packs in order of detection.
+func (reg *Registry) Names() []string {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+	return append([]string(nil), reg.names...)
+}
+
+// Get pack by name.
+func (reg *Registry) Get(name string) (core.Pack, bool) {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+	pack, ok := reg.packs[name]
+	return pack, ok
+}
+
+// Detect the first pack which is able to run content of the directory.
+func (reg *Registry) Detect(directory string) (string, core.Pack, error) {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+	for _, name := range reg.names {
+		if pack := reg.packs[name]; pack.Detect(directory) {
+			return name, pack, nil
+		}
+	}
+	return "", nil, ErrNotDetected
+}
+
+// Select pack by name or detect it if name is empty.
+func (reg *Registry) Select(directory string, name string) (string, core.Pack, error) {
+	if name == "" {
+		return reg.Detect(directory)
+	}
+	pack, ok := reg.Get(name)
+	if !ok {
+		return "", nil, fmt.Errorf("%s: %w", name, ErrUnknownPack)
+	}
+	return name, pack, nil
+}
+
+// HasAnyFile returns true if at least one of files exists in the directory.
+func HasAnyFile(directory string, files ...string) bool {
+	for _, file := range files {
+		if f, err := os.Stat(filepath.Join(directory, file)); err == nil && !f.IsDir() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package packs_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/reddec/git-pipe/core"
+	"github.com/reddec/git-pipe/packs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type filePack string
+
+func (fp filePack) Detect(directory string) bool {
+	return packs.HasAnyFile(directory, string(fp))
+}
+
+func (fp filePack) Run(context.Context, *core.Environment) error {
+	return nil
+}
+
+func TestRegistry_Select(t *testing.T) {
+	registry := packs.NewRegistry()
+	require.NoError(t, registry.Register("compose", filePack("docker-compose.yaml")))
+	require.NoError(t, registry.Register("docker", filePack("Dockerfile")))
+	assert.Equal(t, []string{"compose", "docker"}, registry.Names())
+
+	dir := t.TempDir()
+	_, _, err := registry.Select(dir, "")
+	assert.ErrorIs(t, err, packs.ErrNotDetected)
+
+	for _, file := range []string{"Dockerfile", "docker-compose.yaml"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), nil, 0600))
+	}
+
+	name, _, err := registry.Select(dir, "")
+	require.NoError(t, err)
+	assert.Equal(t, "compose", name, "the first registered pack has priority")
+
+	name, _, err = registry.Select(dir, "docker")
+	require.NoError(t, err)
+	assert.Equal(t, "docker", name)
+
+	_, _, err = registry.Select(dir, "static")
+	assert.ErrorIs(t, err, packs.ErrUnknownPack)
+}
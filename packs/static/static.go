@@ -0,0 +1,250 @@
+// Package static implements pack for static sites. Files are served by the embedded router directly, without container
+// per site. Site could be built by a command in a temporary container before serving.
+package static
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/reddec/git-pipe/core"
+	"github.com/reddec/git-pipe/core/ingress"
+	"github.com/reddec/git-pipe/internal"
+	"github.com/reddec/git-pipe/packs"
+	"go.uber.org/zap"
+)
+
+// Name of the pack.
+const Name = "static"
+
+const (
+	indexFile = "index.html"
+	publicDir = "public"
+	sourceDir = "/src" // directory with repo content in build container
+)
+
+var ErrBuildFailed = errors.New("build failed")
+
+// Pack for static sites.
+type Pack struct{}
+
+// Detect index.html or public directory.
+func (Pack) Detect(directory string) bool {
+	if packs.HasAnyFile(directory, indexFile) {
+		return true
+	}
+	info, err := os.Stat(filepath.Join(directory, publicDir))
+	return err == nil && info.IsDir()
+}
+
+func (Pack) Run(ctx context.Context, env *core.Environment) error {
+	return Run(ctx, env)
+}
+
+func Run(ctx context.Context, env *core.Environment) error {
+	logger := internal.SubLogger(ctx, "static")
+	ctx = internal.WithLogger(ctx, logger)
+
+	if err := packs.CheckEnv(env); err != nil {
+		return err
+	}
+
+	// Each version is served from own snapshot, so work dir could be updated while the site is served
+	snapshot, err := ioutil.TempDir("", "git-pipe-static-*")
+	if err != nil {
+		return fmt.Errorf("create snapshot dir: %w", err)
+	}
+	defer os.RemoveAll(snapshot)
+
+	var siteDir string
+	if env.Manifest.Static.Image != "" {
+		logger.Info("building site", zap.String("image", env.Manifest.Static.Image))
+		siteDir, err = build(ctx, env, snapshot)
+	} else {
+		siteDir, err = copySite(env, snapshot)
+	}
+	if err != nil {
+		return err
+	}
+	if err := removeEscapingLinks(snapshot); err != nil {
+		return fmt.Errorf("check links: %w", err)
+	}
+
+	// Register in the ingress. It atomically switches traffic from the previous version (if any).
+	addressesByDomains := packs.WithAliases(env, map[string][]string{
+		env.Name: {ingress.FileScheme + siteDir},
+	})
+	if err := env.Ingress.Set(ctx, env.Name, addressesByDomains); err != nil {
+		return fmt.Errorf("set ingress: %w", err)
+	}
+	defer packs.ClearIngress(env)
+
+	// Static site has no ports, but ports of the previous version (if any) should be released
+	if err := packs.SetForwards(ctx, env, nil); err != nil {
+		return err
+	}
+	defer packs.ClearForwards(env)
+
+	var domains = make([]string, 0, len(addressesByDomains))
+	for domain := range addressesByDomains {
+		domains = append(domains, domain)
+	}
+	if err := env.DNS.Register(ctx, domains); err != nil {
+		return fmt.Errorf("register DNS: %w", err)
+	}
+
+	// Routes could be restored by the next version if it fails
+	env.Handover.Publish(core.Routes{Domains: addressesByDomains})
+
+	logger.Info("ready", zap.String("dir", siteDir))
+	env.Event.Ready()
+
+	<-ctx.Done()
+	return nil
+}
+
+// copySite copies site directory (from manifest, public or root) to the snapshot. Returns directory with site.
+func copySite(env *core.Environment, snapshot string) (string, error) {
+	dir := env.Manifest.Static.Dir
+	if dir == "" {
+		if info, err := os.Stat(filepath.Join(env.Directory, publicDir)); err == nil && info.IsDir() {
+			dir = publicDir
+		}
+	}
+	content, err := archive.TarWithOptions(filepath.Join(env.Directory, filepath.FromSlash(dir)), &archive.TarOptions{
+		ExcludePatterns: []string{".git"},
+	})
+	if err != nil {
+		return "", fmt.Errorf("archive site: %w", err)
+	}
+	defer content.Close()
+	if err := archive.Untar(content, snapshot, &archive.TarOptions{NoLchown: true}); err != nil {
+		return "", fmt.Errorf("copy site: %w", err)
+	}
+	return snapshot, nil
+}
+
+// build site in container: repo content is copied to the source dir, build command executed and site directory
+// (from manifest or public) copied back to the snapshot. Returns directory with site.
+func build(ctx context.Context, env *core.Environment, snapshot string) (string, error) {
+	logger := internal.LoggerFromContext(ctx)
+	settings := env.Manifest.Static
+	dir := settings.Dir
+	if dir == "" {
+		dir = publicDir
+	}
+
+	image, err := packs.GetImage(ctx, env.Docker, settings.Image)
+	if err != nil {
+		return "", fmt.Errorf("get build image: %w", err)
+	}
+
+	res, err := env.Docker.ContainerCreate(ctx, &container.Config{
+		Image:      image.ID,
+		Cmd:        []string{"sh", "-c", settings.Build},
+		WorkingDir: sourceDir,
+		Env:        toEnvList(env.Vars),
+		Labels: map[string]string{
+			"managed-by": "git-pipe",
+			"git-pipe":   env.Name,
+		},
+	}, &container.HostConfig{
+		Resources: packs.Limits(env),
+	}, &network.NetworkingConfig{}, nil, "")
+	if err != nil {
+		return "", fmt.Errorf("create build container: %w", err)
+	}
+	defer env.Docker.ContainerRemove(context.Background(), res.ID, types.ContainerRemoveOptions{Force: true}) //nolint:errcheck
+
+	content, err := archive.TarWithOptions(env.Directory, &archive.TarOptions{ExcludePatterns: []string{".git"}})
+	if err != nil {
+		return "", fmt.Errorf("archive source: %w", err)
+	}
+	defer content.Close()
+	if err := env.Docker.CopyToContainer(ctx, res.ID, sourceDir, content, types.CopyToContainerOptions{}); err != nil {
+		return "", fmt.Errorf("copy source: %w", err)
+	}
+
+	if err := env.Docker.ContainerStart(ctx, res.ID, types.ContainerStartOptions{}); err != nil {
+		return "", fmt.Errorf("start build container: %w", err)
+	}
+	okC, errC := env.Docker.ContainerWait(ctx, res.ID, container.WaitConditionNotRunning)
+	var exitCode int64
+	select {
+	case status := <-okC:
+		exitCode = status.StatusCode
+	case err := <-errC:
+		return "", fmt.Errorf("wait build container: %w", err)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	streamLogs(ctx, env, res.ID)
+	if exitCode != 0 {
+		return "", fmt.Errorf("%w: exit code %d", ErrBuildFailed, exitCode)
+	}
+
+	output, _, err := env.Docker.CopyFromContainer(ctx, res.ID, path.Join(sourceDir, dir))
+	if err != nil {
+		return "", fmt.Errorf("copy site: %w", err)
+	}
+	defer output.Close()
+	if err := archive.Untar(output, snapshot, &archive.TarOptions{NoLchown: true}); err != nil {
+		return "", fmt.Errorf("extract site: %w", err)
+	}
+	logger.Info("site built")
+	return filepath.Join(snapshot, path.Base(dir)), nil
+}
+
+// removeEscapingLinks from the snapshot: links to host files (ex: /etc/passwd) or to work dirs of other repos should not
+// be served. Broken links are removed too.
+func removeEscapingLinks(dir string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		resolved, err := filepath.EvalSymlinks(file)
+		if err == nil {
+			if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return nil
+			}
+		}
+		return os.Remove(file)
+	})
+}
+
+// streamLogs of container to the logger.
+func streamLogs(ctx context.Context, env *core.Environment, containerID string) {
+	logs, err := env.Docker.ContainerLogs(ctx, containerID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return
+	}
+	defer logs.Close()
+	output := internal.StreamingLogger(internal.LoggerFromContext(ctx))
+	defer output.Close()
+	_, _ = stdcopy.StdCopy(output, output, logs)
+}
+
+func toEnvList(env map[string]string) []string {
+	var ans = make([]string, 0, len(env))
+	for k, v := range env {
+		ans = append(ans, k+"="+v)
+	}
+	return ans
+}
@@ -0,0 +1,90 @@
+package static_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/reddec/git-pipe/core"
+	"github.com/reddec/git-pipe/core/dns/noregister"
+	"github.com/reddec/git-pipe/core/event"
+	"github.com/reddec/git-pipe/core/forward"
+	"github.com/reddec/git-pipe/core/ingress"
+	"github.com/reddec/git-pipe/core/ingress/embedded"
+	"github.com/reddec/git-pipe/internal"
+	"github.com/reddec/git-pipe/packs/static"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+	assert.False(t, static.Pack{}.Detect(dir))
+	write("public/index.html", "hello")
+	write("public/.env", "SECRET=1")
+	write("README.md", "readme")
+	write("public/docs/guide.html", "guide")
+	secret := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, ioutil.WriteFile(secret, []byte("secret"), 0600))
+	require.NoError(t, os.Symlink(secret, filepath.Join(dir, "public", "password.txt")))
+	require.NoError(t, os.Symlink("docs/guide.html", filepath.Join(dir, "public", "guide.html")))
+	assert.True(t, static.Pack{}.Detect(dir))
+
+	router := embedded.New(embedded.ByRoot(), embedded.Files())
+	emitter := event.New(1)
+	env := &core.Environment{
+		Base: core.Base{
+			DNS:     &noregister.NoRegister{},
+			Ingress: ingress.New(router),
+			Forward: forward.New("127.0.0.1", forward.Range{}, nil),
+		},
+		Name:      "site",
+		Directory: dir,
+		Event:     emitter,
+	}
+	task := internal.Spawn(context.Background(), func(ctx context.Context) error {
+		return static.Run(ctx, env)
+	})
+	defer task.Stop()
+
+	select {
+	case <-emitter.OnReady():
+	case <-task.Wait():
+		require.NoError(t, task.Error())
+	}
+
+	get := func(path string) (int, string) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://site"+path, nil))
+		return recorder.Code, recorder.Body.String()
+	}
+
+	// content is served from snapshot
+	write("public/index.html", "changed")
+	code, body := get("/")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "hello", body)
+
+	code, _ = get("/.env")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = get("/README.md")
+	assert.Equal(t, http.StatusNotFound, code, "public directory should be served")
+
+	code, body = get("/guide.html")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "guide", body, "links inside site are followed")
+	code, body = get("/password.txt")
+	assert.Equal(t, http.StatusNotFound, code, "links to host files are not served")
+	assert.NotContains(t, body, "secret")
+	code, body = get("/docs/")
+	assert.Equal(t, http.StatusNotFound, code, "directory listing is disabled")
+	assert.NotContains(t, body, "guide.html")
+}
@@ -0,0 +1,29 @@
+package pipe
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+//nolint:gochecknoglobals
+var NewPoller = newPoller
+
+// Poll source once and deploy changes.
+func (poller *poller) Poll(ctx context.Context, force bool) error {
+	if poller.logger == nil {
+		poller.logger = zap.NewNop()
+	}
+	return poller.poll(ctx, force)
+}
+
+// CurrentDone is closed when serving package stops.
+func (poller *poller) CurrentDone() <-chan struct{} {
+	return poller.current.Wait()
+}
+
+// Stop all packages.
+func (poller *poller) Stop() {
+	_ = poller.next.Stop()
+	_ = poller.current.Stop()
+}
@@ -0,0 +1,239 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/reddec/git-pipe/core"
+	"github.com/reddec/git-pipe/internal"
+	"github.com/reddec/git-pipe/remote"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrUnknownPipeline = errors.New("unknown pipeline")
+	ErrPipelineStopped = errors.New("pipeline stopped")
+	ErrVolumesUnknown  = errors.New("volumes not yet known - pipeline should be deployed at least once")
+)
+
+// Pipeline definition for manager.
+type Pipeline struct {
+	Source   remote.Source
+	Env      *core.Environment
+	Interval time.Duration
+	Revision string // opaque version of definition. Pipelines with the same name and revision are considered unchanged
+}
+
+// NewManager of pipelines. All pipelines will be stopped when context canceled.
+func NewManager(ctx context.Context) *Manager {
+	return &Manager{
+		ctx:       ctx,
+		pipelines: make(map[string]*managed),
+	}
+}
+
+// Manager of running pipelines. Safe for concurrent usage.
+type Manager struct {
+	ctx       context.Context
+	lock      sync.Mutex
+	pipelines map[string]*managed // name -> pipeline
+}
+
+// Sync running pipelines with desired state: starts new, stops removed and restarts changed pipelines.
+// Unchanged pipelines are left untouched. Returns names of started and stopped pipelines.
+func (mg *Manager) Sync(pipelines []Pipeline) (started, stopped []string) {
+	mg.lock.Lock()
+	defer mg.lock.Unlock()
+	logger := internal.LoggerFromContext(mg.ctx)
+
+	var desired = make(map[string]Pipeline, len(pipelines))
+	for _, p := range pipelines {
+		desired[p.Env.Name] = p
+	}
+
+	for name, current := range mg.pipelines {
+		if next, ok := desired[name]; ok && next.Revision == current.definition.Revision {
+			continue
+		}
+		logger.Info("stopping pipeline", zap.String("name", name))
+		current.stop(logger)
+		delete(mg.pipelines, name)
+		stopped = append(stopped, name)
+	}
+
+	for name, definition := range desired {
+		if _, ok := mg.pipelines[name]; ok {
+			continue
+		}
+		logger.Info("starting pipeline", zap.String("name", name))
+		p := &managed{
+			definition: definition,
+			poller:     newPoller(definition.Source, definition.Env),
+		}
+		p.start(mg.ctx)
+		mg.pipelines[name] = p
+		started = append(started, name)
+	}
+
+	sort.Strings(started)
+	sort.Strings(stopped)
+	return started, stopped
+}
+
+// Trigger immediate poll for pipelines matched by filter. Returns names of triggered pipelines.
+func (mg *Manager) Trigger(filter func(name string, source remote.Source) bool) []string {
+	mg.lock.Lock()
+	defer mg.lock.Unlock()
+	var triggered []string
+	for name, p := range mg.pipelines {
+		if filter(name, p.definition.Source) {
+			p.poller.trigger()
+			triggered = append(triggered, name)
+		}
+	}
+	sort.Strings(triggered)
+	return triggered
+}
+
+// List status of all pipelines ordered by name.
+func (mg *Manager) List() []Status {
+	mg.lock.Lock()
+	defer mg.lock.Unlock()
+	var ans = make([]Status, 0, len(mg.pipelines))
+	for _, p := range mg.pipelines {
+		ans = append(ans, p.poller.status.Get())
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		return ans[i].Name < ans[j].Name
+	})
+	return ans
+}
+
+// Status of single pipeline.
+func (mg *Manager) Status(name string) (Status, error) {
+	p, err := mg.get(name)
+	if err != nil {
+		return Status{}, err
+	}
+	return p.poller.status.Get(), nil
+}
+
+// Redeploy package in pipeline even if nothing changed. Returns immediately.
+func (mg *Manager) Redeploy(name string) error {
+	p, err := mg.get(name)
+	if err != nil {
+		return err
+	}
+	if !p.running() {
+		return ErrPipelineStopped
+	}
+	p.poller.redeploy()
+	return nil
+}
+
+// Stop pipeline and wait for package to be stopped. Stopped pipeline will not be polled until Start.
+func (mg *Manager) Stop(name string) error {
+	p, err := mg.get(name)
+	if err != nil {
+		return err
+	}
+	p.stop(internal.LoggerFromContext(mg.ctx))
+	return nil
+}
+
+// Start previously stopped pipeline. Does nothing if pipeline already running.
+func (mg *Manager) Start(name string) error {
+	p, err := mg.get(name)
+	if err != nil {
+		return err
+	}
+	p.start(mg.ctx)
+	return nil
+}
+
+// Backup volumes of pipeline. Volumes are known only after the first deployment.
+func (mg *Manager) Backup(ctx context.Context, name string) error {
+	p, err := mg.get(name)
+	if err != nil {
+		return err
+	}
+	volumes := p.poller.status.Get().Volumes
+	if len(volumes) == 0 {
+		return ErrVolumesUnknown
+	}
+	return p.poller.env.Backup.Backup(ctx, name, volumes)
+}
+
+// Restore volumes of pipeline from backup. Running pipeline will be stopped before restore and started after.
+// Volumes are known only after the first deployment.
+func (mg *Manager) Restore(ctx context.Context, name string) error {
+	p, err := mg.get(name)
+	if err != nil {
+		return err
+	}
+	volumes := p.poller.status.Get().Volumes
+	if len(volumes) == 0 {
+		return ErrVolumesUnknown
+	}
+	wasRunning := p.running()
+	p.stop(internal.LoggerFromContext(mg.ctx))
+	if wasRunning {
+		defer p.start(mg.ctx)
+	}
+	return p.poller.env.Backup.Restore(ctx, name, volumes)
+}
+
+// Close stops all pipelines and waits for them.
+func (mg *Manager) Close() {
+	mg.Sync(nil)
+}
+
+func (mg *Manager) get(name string) (*managed, error) {
+	mg.lock.Lock()
+	defer mg.lock.Unlock()
+	p, ok := mg.pipelines[name]
+	if !ok {
+		return nil, ErrUnknownPipeline
+	}
+	return p, nil
+}
+
+type managed struct {
+	lock       sync.Mutex
+	definition Pipeline
+	poller     *poller
+	task       *internal.Task
+}
+
+func (m *managed) running() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.task != nil
+}
+
+func (m *managed) start(ctx context.Context) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.task != nil {
+		return
+	}
+	m.task = internal.Spawn(ctx, func(ctx context.Context) error {
+		m.poller.run(ctx, m.definition.Interval)
+		return nil
+	})
+}
+
+func (m *managed) stop(logger *zap.Logger) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.task == nil {
+		return
+	}
+	if err := m.task.Stop(); err != nil && !errors.Is(err, context.Canceled) {
+		logger.Warn("failed stop pipeline", zap.String("name", m.definition.Env.Name), zap.Error(err))
+	}
+	m.task = nil
+}
@@ -0,0 +1,131 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/reddec/git-pipe/core"
+	"github.com/reddec/git-pipe/core/event"
+	"github.com/reddec/git-pipe/pipe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_Sync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := pipe.NewManager(ctx)
+	defer manager.Close()
+
+	started, stopped := manager.Sync([]pipe.Pipeline{
+		testPipeline(t, "alfa", "1"),
+		testPipeline(t, "beta", "1"),
+	})
+	assert.Equal(t, []string{"alfa", "beta"}, started)
+	assert.Empty(t, stopped)
+
+	started, stopped = manager.Sync([]pipe.Pipeline{
+		testPipeline(t, "alfa", "1"),
+		testPipeline(t, "beta", "2"),
+		testPipeline(t, "gamma", "1"),
+	})
+	assert.Equal(t, []string{"beta", "gamma"}, started)
+	assert.Equal(t, []string{"beta"}, stopped)
+
+	started, stopped = manager.Sync([]pipe.Pipeline{
+		testPipeline(t, "gamma", "1"),
+	})
+	assert.Empty(t, started)
+	assert.Equal(t, []string{"alfa", "beta"}, stopped)
+}
+
+func testPipeline(t *testing.T, name string, revision string) pipe.Pipeline {
+	return pipe.Pipeline{
+		Source: failedSource{},
+		Env: &core.Environment{
+			Name:      name,
+			Directory: t.TempDir(),
+			Event:     event.Noop(),
+		},
+		Interval: time.Hour,
+		Revision: revision,
+	}
+}
+
+var errSourceUnavailable = errors.New("source unavailable")
+
+type failedSource struct{}
+
+func (fs failedSource) Ref() url.URL {
+	return url.URL{Scheme: "file", Path: "/dev/null"}
+}
+
+func (fs failedSource) Poll(ctx context.Context, targetDir string) (bool, error) {
+	return false, errSourceUnavailable
+}
+
+func TestManager_StopStart(t *testing.T) {
+	pack := &recordingPack{runs: make(chan bool, 2)}
+	require.NoError(t, pipe.Packs.Register("recording", pack))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := pipe.NewManager(ctx)
+	defer manager.Close()
+
+	pipeline := testPipeline(t, "alfa", "1")
+	pipeline.Source = manifestSource{manifest: "pack: recording"}
+	manager.Sync([]pipe.Pipeline{pipeline})
+	assert.False(t, waitRun(t, pack.runs), "first run has nothing to take over")
+
+	require.NoError(t, manager.Stop("alfa"))
+	require.NoError(t, manager.Start("alfa"))
+	assert.False(t, waitRun(t, pack.runs), "stopped package should not be taken over after start")
+}
+
+// recordingPack reports takeover flag of each run and serves till stopped.
+type recordingPack struct {
+	runs chan bool
+}
+
+func (rp *recordingPack) Detect(string) bool {
+	return false
+}
+
+func (rp *recordingPack) Run(ctx context.Context, env *core.Environment) error {
+	rp.runs <- env.Handover.Takeover()
+	env.Event.Ready()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func waitRun(t *testing.T, runs <-chan bool) bool {
+	select {
+	case takeover := <-runs:
+		return takeover
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "package not started")
+		return false
+	}
+}
+
+// manifestSource writes manifest to the work dir on each poll.
+type manifestSource struct {
+	manifest string
+}
+
+func (ms manifestSource) Ref() url.URL {
+	return url.URL{Scheme: "file", Path: "/dev/null"}
+}
+
+func (ms manifestSource) Poll(ctx context.Context, targetDir string) (bool, error) {
+	err := ioutil.WriteFile(filepath.Join(targetDir, core.ManifestFile), []byte(ms.manifest), 0600)
+	return err == nil, err
+}
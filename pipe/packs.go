@@ -0,0 +1,20 @@
+package pipe
+
+import (
+	"github.com/reddec/git-pipe/packs"
+	"github.com/reddec/git-pipe/packs/compose"
+	"github.com/reddec/git-pipe/packs/dckr"
+	"github.com/reddec/git-pipe/packs/static"
+)
+
+// Packs available for pipelines. Packs are detected in order of registration, so more specific packs should be
+// registered first. Repository can select pack explicitly by manifest (see core.Manifest).
+var Packs = defaultPacks() //nolint:gochecknoglobals
+
+func defaultPacks() *packs.Registry {
+	registry := packs.NewRegistry()
+	_ = registry.Register(compose.Name, compose.Pack{})
+	_ = registry.Register(dckr.Name, dckr.Pack{})
+	_ = registry.Register(static.Name, static.Pack{})
+	return registry
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/reddec/git-pipe/core"
 	"github.com/reddec/git-pipe/core/event"
+	"github.com/reddec/git-pipe/core/forward"
 	"github.com/reddec/git-pipe/core/ingress"
 	"github.com/reddec/git-pipe/core/network"
 	"github.com/reddec/git-pipe/internal"
@@ -270,6 +271,7 @@ func testBaseEnv(t *testing.T, name string) *testContext {
 		Base: core.Base{
 			DNS:     tc.testDNS,
 			Ingress: ingress.New(tc.ingressBackend),
+			Forward: forward.New("127.0.0.1", forward.Range{}, net),
 			Backup:  tc.backup,
 			Network: net,
 			Docker:  cli,
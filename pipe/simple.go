@@ -6,52 +6,122 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/reddec/git-pipe/core"
 	"github.com/reddec/git-pipe/internal"
-	"github.com/reddec/git-pipe/packs/compose"
-	"github.com/reddec/git-pipe/packs/dckr"
 	"github.com/reddec/git-pipe/remote"
 	"go.uber.org/zap"
 )
 
 var (
-	errUnknownPackage = errors.New("unknown packaging for repo")
+	errPackageStopped       = errors.New("package stopped")
+	errCheckoutNotSupported = errors.New("source does not support checkout")
 )
 
 const (
 	defaultPermission = 0700
 )
 
+//nolint:gochecknoglobals
+var (
+	pollsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitpipe_polls_total",
+		Help: "Total number of repo polls",
+	}, []string{"repo"})
+	pollFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitpipe_poll_failures_total",
+		Help: "Total number of failed repo polls",
+	}, []string{"repo"})
+	deploysTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitpipe_deploys_total",
+		Help: "Total number of finished deployments by outcome",
+	}, []string{"repo", "outcome"})
+	deployDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gitpipe_deploy_duration_seconds",
+		Help:    "Time from package start till ready or failure",
+		Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}, //nolint:gomnd
+	}, []string{"repo", "outcome"})
+)
+
+const (
+	outcomeSuccess  = "success"
+	outcomeFailure  = "failure"
+	outcomeCanceled = "canceled"
+)
+
 func Run(ctx context.Context, source remote.Source, env *core.Environment, interval time.Duration) {
-	logger := internal.SubLogger(ctx, env.Name)
-	p := &poller{
-		env:    env,
-		logger: logger,
+	newPoller(source, env).run(ctx, interval)
+}
+
+func newPoller(source remote.Source, env *core.Environment) *poller {
+	ref := source.Ref()
+	st := &status{value: Status{
+		Name:   env.Name,
+		Source: ref.Redacted(),
+		State:  StateStopped,
+	}}
+	return &poller{
+		env:    st.wrap(env),
 		source: source,
+		wakeup: make(chan struct{}, 1),
+		status: st,
 	}
-
-	p.run(internal.WithLogger(ctx, logger), interval)
 }
 
 type poller struct {
 	env     *core.Environment
-	current *internal.Task
+	current *generation // serving package
+	next    *generation // package which will replace current once ready
 	logger  *zap.Logger
 	source  remote.Source
+	wakeup  chan struct{}
+	forced  int32
+	status  *status
+	good    string // last version which became ready
+	bad     string // version which failed before ready
+}
+
+// trigger immediate poll. Never blocks.
+func (poller *poller) trigger() {
+	select {
+	case poller.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// redeploy package even if nothing changed. Never blocks.
+func (poller *poller) redeploy() {
+	atomic.StoreInt32(&poller.forced, 1)
+	poller.trigger()
 }
 
 func (poller *poller) run(ctx context.Context, interval time.Duration) {
+	poller.logger = internal.SubLogger(ctx, poller.env.Name)
+	ctx = internal.WithLogger(ctx, poller.logger)
+
+	poller.status.setState(StatePolling, nil)
+	defer poller.status.setState(StateStopped, nil)
+
 	var force = true
 	var updater = time.NewTicker(interval)
 	defer updater.Stop()
 
 LOOP:
 	for {
+		if atomic.SwapInt32(&poller.forced, 0) == 1 {
+			force = true
+			poller.bad = "" // give a chance to previously failed version
+		}
 		if err := poller.poll(ctx, force); err != nil {
 			force = true
+			pollFailures.WithLabelValues(poller.env.Name).Inc()
 			poller.logger.Warn("poll failed", zap.Error(err))
+			poller.status.setState(StateFailed, err)
 		} else {
 			force = false
 		}
@@ -61,64 +131,293 @@ LOOP:
 			select {
 			case <-updater.C:
 				break STATE
+			case <-poller.wakeup:
+				poller.logger.Info("poll triggered")
+				break STATE
 			case <-ctx.Done():
 				break LOOP
 			case <-poller.current.Wait():
+				if poller.next != nil {
+					// new version is still starting and will serve traffic once ready
+					poller.logger.Info("previous version stopped", zap.Error(poller.current.Error()))
+					poller.current, poller.next = poller.next, nil
+					continue
+				}
 				poller.logger.Warn("package stopped", zap.Error(poller.current.Error()))
+				poller.status.finishDeploy(outcomeFailure)
+				poller.status.setState(StateFailed, packageError(poller.current.Error()))
+				poller.failed(ctx, poller.current)
 				poller.current = nil
 				force = true
+			case <-poller.current.pendingReady():
+				poller.ready(poller.current)
+			case <-poller.next.Ready():
+				poller.logger.Info("new version is ready, stopping previous")
+				poller.ready(poller.next)
+				poller.current.handover.Replace()
+				if err := poller.current.Stop(); err != nil && !errors.Is(err, context.Canceled) {
+					poller.logger.Warn("failed cleanup and stop previous package", zap.Error(err))
+				}
+				poller.current, poller.next = poller.next, nil
+			case <-poller.next.Wait():
+				poller.logger.Warn("new version failed, previous version is kept", zap.Error(poller.next.Error()))
+				poller.status.finishDeploy(outcomeFailure)
+				poller.status.setState(StateFailed, packageError(poller.next.Error()))
+				// previous version is still running, so only failed version should be retried
+				force = !poller.failed(ctx, poller.next)
+				poller.next = nil
 			}
 		}
 	}
 
+	if err := poller.next.Stop(); err != nil && !errors.Is(err, context.Canceled) {
+		poller.logger.Warn("failed cleanup and stop new package", zap.Error(err))
+	}
 	if err := poller.current.Stop(); err != nil && !errors.Is(err, context.Canceled) {
 		poller.logger.Warn("failed cleanup and stop package", zap.Error(err))
 	}
+	// stopped generations should not be taken over after restart
+	poller.current, poller.next = nil, nil
 }
 
 func (poller *poller) poll(ctx context.Context, force bool) error {
 	poller.logger.Debug("polling for updates")
+	pollsTotal.WithLabelValues(poller.env.Name).Inc()
 	if err := os.MkdirAll(poller.env.Directory, defaultPermission); err != nil {
 		return fmt.Errorf("create dir: %w", err)
 	}
 	changed, err := poller.source.Poll(ctx, poller.env.Directory)
+	poller.status.update(func(value *Status) {
+		value.Polled = time.Now()
+	})
 	if err != nil {
 		return fmt.Errorf("poll source: %w", err)
 	}
+	version := poller.version(ctx)
+	if version != "" && version == poller.bad {
+		if poller.good == "" {
+			poller.logger.Debug("version failed before and there is nothing to roll back to", zap.String("version", version))
+			return nil
+		}
+		// keep work dir consistent with the last good version
+		if err := poller.checkout(ctx, poller.good); err != nil {
+			return fmt.Errorf("roll back to %s: %w", poller.good, err)
+		}
+		poller.logger.Debug("version failed before, using last good version", zap.String("version", version), zap.String("good", poller.good))
+		version = poller.good
+		changed = false
+	}
+	poller.status.update(func(value *Status) {
+		value.Version = version
+	})
 	if !changed && !force {
 		return nil
 	}
 
-	// stop old
-	if err := poller.current.Stop(); err != nil {
-		return fmt.Errorf("stop previous package: %w", err)
+	// abandon not yet ready version
+	poller.status.finishDeploy(outcomeCanceled)
+	if err := poller.next.Stop(); err != nil && !errors.Is(err, context.Canceled) {
+		poller.logger.Warn("failed cleanup and stop new package", zap.Error(err))
 	}
+	poller.next = nil
 
-	var task *internal.Task
-	switch {
-	case hasAnyFile(poller.env.Directory, "docker-compose.yaml", "docker-compose.yml"):
-		poller.logger.Debug("package detected as docker-compose")
-		task = internal.Spawn(ctx, func(ctx context.Context) error {
-			return compose.Run(ctx, poller.env)
-		})
-	case hasAnyFile(poller.env.Directory, "Dockerfile"):
-		poller.logger.Debug("package detected as Dockerfile")
-		task = internal.Spawn(ctx, func(ctx context.Context) error {
-			return dckr.Run(ctx, poller.env)
-		})
+	dir := poller.deployDir()
+	manifest, err := core.LoadManifest(dir)
+	if err != nil {
+		return fmt.Errorf("load manifest: %w", err)
+	}
+	name, pack, err := Packs.Select(dir, manifest.Pack)
+	if err != nil {
+		return fmt.Errorf("select pack: %w", err)
+	}
+	poller.logger.Debug("package detected", zap.String("pack", name), zap.Bool("explicit", manifest.Pack != ""))
+
+	// current package could stop after the last check of its state, finished package should not be taken over
+	select {
+	case <-poller.current.Wait():
+		poller.logger.Warn("package stopped", zap.Error(poller.current.Error()))
+		poller.current = nil
 	default:
-		return errUnknownPackage
 	}
-	poller.current = task
+
+	// old version will be stopped once new one is ready or when new one asks for it
+	gen := poller.spawn(ctx, pack.Run, *manifest)
+	gen.version = version
+	if poller.current == nil {
+		poller.current = gen
+	} else {
+		poller.next = gen
+	}
+	poller.status.startDeploy()
 	return nil
 }
 
-func hasAnyFile(root string, files ...string) bool {
-	for _, file := range files {
-		if f, err := os.Stat(filepath.Join(root, file)); err == nil && !f.IsDir() {
-			return true
+// deployDir is directory with package: work dir or its subdirectory for scoped sources. Work dir could be a link to
+// immutable snapshot (see remote/snapshot), so it is resolved and each generation keeps using own content.
+func (poller *poller) deployDir() string {
+	dir := poller.env.Directory
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	if scoped, ok := poller.source.(remote.Scoped); ok && scoped.Subdir() != "" {
+		return filepath.Join(dir, filepath.FromSlash(scoped.Subdir()))
+	}
+	return dir
+}
+
+// version of content in work dir. Empty if source is not versioned.
+func (poller *poller) version(ctx context.Context) string {
+	versioned, ok := poller.source.(remote.Versioned)
+	if !ok {
+		return ""
+	}
+	version, err := versioned.Version(ctx, poller.env.Directory)
+	if err != nil {
+		poller.logger.Warn("failed to get version", zap.Error(err))
+		return ""
+	}
+	return version
+}
+
+func (poller *poller) checkout(ctx context.Context, version string) error {
+	checkouter, ok := poller.source.(remote.Checkouter)
+	if !ok {
+		return errCheckoutNotSupported
+	}
+	return checkouter.Checkout(ctx, poller.env.Directory, version)
+}
+
+// ready remembers version of the generation as good.
+func (poller *poller) ready(gen *generation) {
+	gen.acknowledged = true
+	if gen.version != "" {
+		poller.good = gen.version
+	}
+}
+
+// failed generation will be marked as bad if it failed before ready and work dir was rolled back to the last good
+// version. Sources without checkout support are retried as is. Returns true if roll back happened.
+func (poller *poller) failed(ctx context.Context, gen *generation) bool {
+	if gen.wasReady() || gen.version == "" || poller.good == "" || gen.version == poller.good {
+		return false
+	}
+	if err := poller.checkout(ctx, poller.good); err != nil {
+		poller.logger.Warn("failed to roll back", zap.String("good", poller.good), zap.Error(err))
+		return false
+	}
+	poller.bad = gen.version
+	poller.logger.Info("rolled back to the last good version", zap.String("bad", poller.bad), zap.String("good", poller.good))
+	poller.status.update(func(value *Status) {
+		value.Version = poller.good
+	})
+	return true
+}
+
+// spawn new generation of package. Generation takes over resources from the current one if it is running.
+func (poller *poller) spawn(ctx context.Context, pack func(ctx context.Context, env *core.Environment) error, manifest core.Manifest) *generation {
+	var stopPrevious func()
+	var previousHandover *core.Handover
+	if previous := poller.current; previous != nil {
+		previousHandover = previous.handover
+		logger := poller.logger
+		stopPrevious = func() {
+			logger.Info("stopping previous version")
+			if err := previous.Stop(); err != nil && !errors.Is(err, context.Canceled) {
+				logger.Warn("failed cleanup and stop previous package", zap.Error(err))
+			}
 		}
 	}
+	gen := &generation{
+		handover: core.NewHandover(previousHandover, stopPrevious),
+		ready:    make(chan struct{}),
+	}
+	env := *poller.env
+	env.Directory = poller.deployDir()
+	env.Manifest = manifest
+	env.Handover = gen.handover
+	env.Event = &readyEvent{event: poller.env.Event, ready: gen.ready}
+	gen.task = internal.Spawn(ctx, func(ctx context.Context) error {
+		defer gen.handover.Finish()
+		return pack(ctx, &env)
+	})
+	return gen
+}
+
+// generation of running package. Nil value is valid and means no package.
+type generation struct {
+	task         *internal.Task
+	handover     *core.Handover
+	ready        chan struct{} // closed when package is ready
+	version      string        // deployed version, if source supports versions
+	acknowledged bool          // readiness processed by poller
+}
+
+// Ready channel closed when package is ready. Returns nil channel for nil generation.
+func (gen *generation) Ready() <-chan struct{} {
+	if gen == nil {
+		return nil
+	}
+	return gen.ready
+}
+
+// pendingReady returns ready channel till readiness is acknowledged. Returns nil channel for nil generation.
+func (gen *generation) pendingReady() <-chan struct{} {
+	if gen == nil || gen.acknowledged {
+		return nil
+	}
+	return gen.ready
+}
+
+// wasReady checks that package reported readiness.
+func (gen *generation) wasReady() bool {
+	select {
+	case <-gen.Ready():
+		return true
+	default:
+		return false
+	}
+}
 
-	return false
+// Wait for package completion.
+func (gen *generation) Wait() <-chan struct{} {
+	if gen == nil {
+		return nil
+	}
+	return gen.task.Wait()
+}
+
+// Stop package and wait till the end.
+func (gen *generation) Stop() error {
+	if gen == nil {
+		return nil
+	}
+	return gen.task.Stop()
+}
+
+// Error returned by package.
+func (gen *generation) Error() error {
+	if gen == nil {
+		return nil
+	}
+	return gen.task.Error()
+}
+
+type readyEvent struct {
+	event core.Event
+	ready chan struct{}
+	once  sync.Once
+}
+
+func (re *readyEvent) Ready() {
+	re.once.Do(func() {
+		close(re.ready)
+	})
+	re.event.Ready()
+}
+
+func packageError(err error) error {
+	if err == nil {
+		return errPackageStopped
+	}
+	return err
 }
@@ -0,0 +1,278 @@
+package pipe_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/reddec/git-pipe/core"
+	"github.com/reddec/git-pipe/core/event"
+	"github.com/reddec/git-pipe/pipe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const versionFile = "VERSION"
+
+func TestRun_rollback(t *testing.T) {
+	pack := &versionedPack{bad: map[string]bool{"v2": true}}
+	require.NoError(t, pipe.Packs.Register("versioned", pack))
+	source := &versionedSource{head: "v1"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pipe.Run(ctx, source, &core.Environment{Name: "app", Directory: t.TempDir(), Event: event.Noop()}, 10*time.Millisecond)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitVersions(t, pack, "v1")
+	assert.Empty(t, source.checkouts())
+
+	source.setHead("v2")
+	require.Eventually(t, func() bool {
+		return len(source.checkouts()) >= 2 //nolint:gomnd
+	}, 5*time.Second, 10*time.Millisecond, "bad version should be rolled back and skipped on the next polls")
+	assert.Equal(t, []string{"v1", "v2"}, pack.versions(), "known-bad version should not be deployed again")
+	for _, version := range source.checkouts() {
+		assert.Equal(t, "v1", version, "work dir should be rolled back to the last good version")
+	}
+
+	source.setHead("v3")
+	waitVersions(t, pack, "v1", "v2", "v3")
+}
+
+func TestRun_retryWithoutCheckout(t *testing.T) {
+	pack := &versionedPack{bad: map[string]bool{"v2": true}}
+	require.NoError(t, pipe.Packs.Register("versioned", pack))
+	source := &versionedSource{head: "v1"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pipe.Run(ctx, versionedOnly{source}, &core.Environment{Name: "app", Directory: t.TempDir(), Event: event.Noop()}, 10*time.Millisecond)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitVersions(t, pack, "v1")
+
+	source.setHead("v2")
+	require.Eventually(t, func() bool {
+		var retries int
+		for _, version := range pack.versions() {
+			if version == "v2" {
+				retries++
+			}
+		}
+		return retries >= 2 //nolint:gomnd
+	}, 5*time.Second, 10*time.Millisecond, "failed version should be retried if source can not roll back")
+	assert.Empty(t, source.checkouts())
+
+	source.setHead("v3")
+	require.Eventually(t, func() bool {
+		versions := pack.versions()
+		return versions[len(versions)-1] == "v3"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPoll_finishedCurrent(t *testing.T) {
+	pack := &takeoverPack{}
+	require.NoError(t, pipe.Packs.Register("takeover", pack))
+	source := &versionedSource{head: "v1", pack: "takeover"}
+	poller := pipe.NewPoller(source, &core.Environment{Name: "app", Directory: t.TempDir(), Event: event.Noop()})
+	defer poller.Stop()
+
+	ctx := context.Background()
+	require.NoError(t, poller.Poll(ctx, true))
+	<-poller.CurrentDone()
+
+	// package stopped, but poller did not process it yet
+	source.setHead("v2")
+	require.NoError(t, poller.Poll(ctx, false))
+	<-poller.CurrentDone()
+	assert.Equal(t, []bool{false, false}, pack.takeovers(), "finished package should not be taken over")
+}
+
+func TestPoll_metrics(t *testing.T) {
+	source := &versionedSource{head: "v1", pack: "takeover"}
+	require.NoError(t, pipe.Packs.Register("takeover", &takeoverPack{}))
+	poller := pipe.NewPoller(source, &core.Environment{Name: "metrics", Directory: t.TempDir(), Event: event.Noop()})
+	defer poller.Stop()
+	require.NoError(t, poller.Poll(context.Background(), true))
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	var polls float64
+	var runtime bool
+	for _, family := range families {
+		switch family.GetName() {
+		case "go_goroutines":
+			runtime = true
+		case "gitpipe_polls_total":
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "repo" && label.GetValue() == "metrics" {
+						polls = metric.GetCounter().GetValue()
+					}
+				}
+			}
+		}
+	}
+	assert.Equal(t, float64(1), polls)
+	assert.True(t, runtime, "Go runtime metrics are exposed")
+}
+
+func waitVersions(t *testing.T, pack *versionedPack, versions ...string) {
+	require.Eventually(t, func() bool {
+		return len(pack.versions()) >= len(versions)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, versions, pack.versions())
+}
+
+var errBadVersion = errors.New("bad version")
+
+// versionedPack records deployed versions. Bad versions fail before ready, others serve till stopped.
+type versionedPack struct {
+	bad  map[string]bool
+	lock sync.Mutex
+	runs []string
+}
+
+func (vp *versionedPack) Detect(string) bool {
+	return false
+}
+
+func (vp *versionedPack) Run(ctx context.Context, env *core.Environment) error {
+	data, err := ioutil.ReadFile(filepath.Join(env.Directory, versionFile))
+	if err != nil {
+		return err
+	}
+	version := string(data)
+	vp.lock.Lock()
+	vp.runs = append(vp.runs, version)
+	vp.lock.Unlock()
+	if vp.bad[version] {
+		return errBadVersion
+	}
+	env.Event.Ready()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (vp *versionedPack) versions() []string {
+	vp.lock.Lock()
+	defer vp.lock.Unlock()
+	return append([]string(nil), vp.runs...)
+}
+
+// takeoverPack records whether package took over previous one and stops immediately.
+type takeoverPack struct {
+	lock sync.Mutex
+	runs []bool
+}
+
+func (tp *takeoverPack) Detect(string) bool {
+	return false
+}
+
+func (tp *takeoverPack) Run(_ context.Context, env *core.Environment) error {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	tp.runs = append(tp.runs, env.Handover.Takeover())
+	return nil
+}
+
+func (tp *takeoverPack) takeovers() []bool {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	return append([]bool(nil), tp.runs...)
+}
+
+// versionedOnly hides checkout support of the source.
+type versionedOnly struct {
+	source *versionedSource
+}
+
+func (vo versionedOnly) Ref() url.URL {
+	return vo.source.Ref()
+}
+
+func (vo versionedOnly) Poll(ctx context.Context, targetDir string) (bool, error) {
+	return vo.source.Poll(ctx, targetDir)
+}
+
+func (vo versionedOnly) Version(ctx context.Context, targetDir string) (string, error) {
+	return vo.source.Version(ctx, targetDir)
+}
+
+// versionedSource resets work dir to the head version on each poll, like git source does.
+type versionedSource struct {
+	lock    sync.Mutex
+	head    string
+	pack    string // pack in manifest, default is versioned
+	checked []string
+}
+
+func (vs *versionedSource) Ref() url.URL {
+	return url.URL{Scheme: "file", Path: "/dev/null"}
+}
+
+func (vs *versionedSource) Poll(ctx context.Context, targetDir string) (bool, error) {
+	vs.lock.Lock()
+	head := vs.head
+	vs.lock.Unlock()
+	current, _ := vs.Version(ctx, targetDir)
+	if err := vs.write(targetDir, head); err != nil {
+		return false, err
+	}
+	return current != head, nil
+}
+
+func (vs *versionedSource) Version(_ context.Context, targetDir string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(targetDir, versionFile))
+	return string(data), err
+}
+
+func (vs *versionedSource) Checkout(_ context.Context, targetDir string, version string) error {
+	vs.lock.Lock()
+	vs.checked = append(vs.checked, version)
+	vs.lock.Unlock()
+	return vs.write(targetDir, version)
+}
+
+func (vs *versionedSource) setHead(version string) {
+	vs.lock.Lock()
+	defer vs.lock.Unlock()
+	vs.head = version
+}
+
+func (vs *versionedSource) checkouts() []string {
+	vs.lock.Lock()
+	defer vs.lock.Unlock()
+	return append([]string(nil), vs.checked...)
+}
+
+func (vs *versionedSource) write(targetDir, version string) error {
+	pack := vs.pack
+	if pack == "" {
+		pack = "versioned"
+	}
+	if err := ioutil.WriteFile(filepath.Join(targetDir, core.ManifestFile), []byte("pack: "+pack), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(targetDir, versionFile), []byte(version), 0600)
+}
@@ -0,0 +1,165 @@
+package pipe
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/reddec/git-pipe/core"
+	"github.com/reddec/git-pipe/internal"
+)
+
+// State of pipeline.
+type State string
+
+const (
+	StateStopped   State = "stopped"   // pipeline not running
+	StatePolling   State = "polling"   // waiting for the first successful poll
+	StateDeploying State = "deploying" // package is starting
+	StateRunning   State = "running"   // package is ready
+	StateFailed    State = "failed"    // last poll or package failed
+)
+
+// Status of pipeline.
+type Status struct {
+	Name     string    `json:"name"`
+	Source   string    `json:"source"`             // redacted source URL
+	State    State     `json:"state"`              // pipeline state
+	Version  string    `json:"version,omitempty"`  // current version (commit) if supported by source
+	Domains  []string  `json:"domains,omitempty"`  // registered domains
+	Volumes  []string  `json:"volumes,omitempty"`  // volumes under backup
+	Polled   time.Time `json:"polled,omitempty"`   // last poll time
+	Deployed time.Time `json:"deployed,omitempty"` // last time when package became ready
+	Error    string    `json:"error,omitempty"`    // last error
+}
+
+// status tracker. Wraps environment to collect information from packages.
+type status struct {
+	lock      sync.RWMutex
+	value     Status
+	deploying time.Time // start time of not finished deployment
+}
+
+func (st *status) Get() Status {
+	st.lock.RLock()
+	defer st.lock.RUnlock()
+	cp := st.value
+	cp.Domains = append([]string(nil), st.value.Domains...)
+	cp.Volumes = append([]string(nil), st.value.Volumes...)
+	return cp
+}
+
+func (st *status) update(fn func(value *Status)) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	fn(&st.value)
+}
+
+func (st *status) setState(state State, err error) {
+	st.update(func(value *Status) {
+		value.State = state
+		if err != nil {
+			value.Error = err.Error()
+		} else if state != StateFailed {
+			value.Error = ""
+		}
+	})
+}
+
+// startDeploy switches state to deploying and remembers start time for metrics.
+func (st *status) startDeploy() {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.value.State = StateDeploying
+	st.value.Error = ""
+	st.deploying = time.Now()
+}
+
+// finishDeploy records outcome of current deployment, if any.
+func (st *status) finishDeploy(outcome string) {
+	st.lock.Lock()
+	started, name := st.deploying, st.value.Name
+	st.deploying = time.Time{}
+	st.lock.Unlock()
+	if started.IsZero() {
+		return
+	}
+	deploysTotal.WithLabelValues(name, outcome).Inc()
+	deployDuration.WithLabelValues(name, outcome).Observe(time.Since(started).Seconds())
+}
+
+// wrap environment to track events, routes and volumes.
+func (st *status) wrap(env *core.Environment) *core.Environment {
+	cp := *env
+	cp.Event = &trackedEvent{status: st, event: env.Event}
+	cp.Ingress = &trackedIngress{status: st, ingress: env.Ingress}
+	cp.Backup = &trackedStorage{status: st, storage: env.Backup}
+	return &cp
+}
+
+type trackedEvent struct {
+	status *status
+	event  core.Event
+}
+
+func (te *trackedEvent) Ready() {
+	te.status.update(func(value *Status) {
+		value.State = StateRunning
+		value.Deployed = time.Now()
+		value.Error = ""
+	})
+	te.status.finishDeploy(outcomeSuccess)
+	te.event.Ready()
+}
+
+type trackedIngress struct {
+	status  *status
+	ingress core.Ingress
+}
+
+func (ti *trackedIngress) Clear(ctx context.Context, group string) error {
+	ti.status.update(func(value *Status) {
+		value.Domains = nil
+	})
+	return ti.ingress.Clear(ctx, group)
+}
+
+func (ti *trackedIngress) Set(ctx context.Context, group string, domainAddresses map[string][]string) error {
+	var domains = make([]string, 0, len(domainAddresses))
+	for domain := range domainAddresses {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	ti.status.update(func(value *Status) {
+		value.Domains = domains
+	})
+	return ti.ingress.Set(ctx, group, domainAddresses)
+}
+
+type trackedStorage struct {
+	status  *status
+	storage core.Storage
+}
+
+func (ts *trackedStorage) Restore(ctx context.Context, name string, volumeNames []string) error {
+	ts.remember(volumeNames)
+	return ts.storage.Restore(ctx, name, volumeNames)
+}
+
+func (ts *trackedStorage) Backup(ctx context.Context, name string, volumeNames []string) error {
+	ts.remember(volumeNames)
+	return ts.storage.Backup(ctx, name, volumeNames)
+}
+
+func (ts *trackedStorage) Schedule(ctx context.Context, name string, volumeNames []string) *internal.Task {
+	ts.remember(volumeNames)
+	return ts.storage.Schedule(ctx, name, volumeNames)
+}
+
+func (ts *trackedStorage) remember(volumeNames []string) {
+	volumes := append([]string(nil), volumeNames...)
+	ts.status.update(func(value *Status) {
+		value.Volumes = volumes
+	})
+}
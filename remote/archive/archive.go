@@ -0,0 +1,328 @@
+// Package archive implements source which downloads tar (optionally gzipped) or zip archive over HTTP(S).
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/reddec/git-pipe/internal"
+	"github.com/reddec/git-pipe/remote"
+	"github.com/reddec/git-pipe/remote/snapshot"
+	"go.uber.org/zap"
+)
+
+const (
+	// Prefix for URL scheme to force archive source for URLs without known extension (ex: archive+https://).
+	Prefix = "archive+"
+
+	defaultPermission = 0755
+)
+
+var ErrUnexpectedStatus = errors.New("unexpected status code")
+
+// Supported checks that URL points to archive: it has explicit prefix or known extension.
+func Supported(rawURL string) bool {
+	if strings.HasPrefix(rawURL, Prefix) {
+		return true
+	}
+	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
+		return false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return isZip(u.Path) || isTar(u.Path)
+}
+
+// New archive source. Credentials from URL or username and password are used for basic authorization,
+// password without username is used as bearer token.
+func New(rawURL string, credentials remote.Credentials) (remote.Source, error) {
+	u, err := url.Parse(strings.TrimPrefix(rawURL, Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	if u.User != nil && credentials.Password == "" {
+		credentials.Username = u.User.Username()
+		credentials.Password, _ = u.User.Password()
+	}
+	download := *u
+	download.User = nil
+	download.Fragment = ""
+	return &Archive{
+		url:         *u,
+		download:    download.String(),
+		credentials: credentials,
+		client:      http.DefaultClient,
+	}, nil
+}
+
+// Archive source. Changes are detected by ETag and Last-Modified headers and by content hash, so
+// servers without conditional requests support are fine too. State is kept in memory only.
+// Each version is extracted to own snapshot, so the last versions could be checked out again.
+type Archive struct {
+	url          url.URL
+	download     string
+	credentials  remote.Credentials
+	client       *http.Client
+	etag         string
+	lastModified string
+	version      string // SHA-256 of the last downloaded archive
+}
+
+func (arc *Archive) Ref() url.URL {
+	return arc.url
+}
+
+func (arc *Archive) Poll(ctx context.Context, targetDir string) (bool, error) {
+	logger := internal.LoggerFromContext(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, arc.download, nil)
+	if err != nil {
+		return false, fmt.Errorf("create request: %w", err)
+	}
+	arc.authorize(req)
+	if arc.version != "" {
+		if arc.etag != "" {
+			req.Header.Set("If-None-Match", arc.etag)
+		}
+		if arc.lastModified != "" {
+			req.Header.Set("If-Modified-Since", arc.lastModified)
+		}
+	}
+
+	res, err := arc.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("download archive: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusNotModified:
+		logger.Debug("archive not modified")
+		return false, nil
+	case http.StatusOK:
+	default:
+		return false, fmt.Errorf("download archive: %d: %w", res.StatusCode, ErrUnexpectedStatus)
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(targetDir), ".archive-*")
+	if err != nil {
+		return false, fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmpFile, hash), res.Body)
+	if err != nil {
+		return false, fmt.Errorf("download archive: %w", err)
+	}
+	version := hex.EncodeToString(hash.Sum(nil))
+	if version == arc.version {
+		logger.Debug("archive content not changed")
+		arc.saveValidators(res)
+		return false, nil
+	}
+
+	logger.Debug("extracting archive", zap.Int64("size", size), zap.String("version", version))
+	if err := extract(tmpFile, size, isZip(arc.url.Path) || res.Header.Get("Content-Type") == "application/zip", targetDir, version); err != nil {
+		return false, err
+	}
+	arc.version = version
+	arc.saveValidators(res)
+	return true, nil
+}
+
+// saveValidators for conditional requests. Validators should be saved only once content is applied, otherwise
+// failed update will never be retried.
+func (arc *Archive) saveValidators(res *http.Response) {
+	arc.etag, arc.lastModified = res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+}
+
+// Version is SHA-256 hash of archive in target dir.
+func (arc *Archive) Version(_ context.Context, targetDir string) (string, error) {
+	return snapshot.Current(targetDir)
+}
+
+// Checkout one of the last versions to target dir.
+func (arc *Archive) Checkout(_ context.Context, targetDir string, version string) error {
+	return snapshot.Checkout(targetDir, version)
+}
+
+func (arc *Archive) authorize(req *http.Request) {
+	switch {
+	case arc.credentials.Username != "":
+		req.SetBasicAuth(arc.credentials.Username, arc.credentials.Password)
+	case arc.credentials.Password != "":
+		req.Header.Set("Authorization", "Bearer "+arc.credentials.Password)
+	}
+}
+
+// extract archive as new version of target dir. If archive has single root directory (like archives
+// from GitHub), content of the directory is used.
+func extract(file *os.File, size int64, zipped bool, targetDir string, version string) error {
+	tmpDir, err := ioutil.TempDir(filepath.Dir(targetDir), ".extract-*")
+	if err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if zipped {
+		err = extractZip(file, size, tmpDir)
+	} else {
+		err = extractTar(file, tmpDir)
+	}
+	if err != nil {
+		return fmt.Errorf("extract archive: %w", err)
+	}
+
+	root := tmpDir
+	if list, err := ioutil.ReadDir(tmpDir); err == nil && len(list) == 1 && list[0].IsDir() {
+		root = filepath.Join(tmpDir, list[0].Name())
+	}
+
+	return snapshot.Commit(targetDir, root, version)
+}
+
+func extractTar(file *os.File, dir string) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind: %w", err)
+	}
+	var stream io.Reader = bufio.NewReader(file)
+	if magic, err := stream.(*bufio.Reader).Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b { //nolint:gomnd
+		gz, err := gzip.NewReader(stream)
+		if err != nil {
+			return fmt.Errorf("open gzip: %w", err)
+		}
+		defer gz.Close()
+		stream = gz
+	}
+	reader := tar.NewReader(stream)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %w", err)
+		}
+		dest := destination(dir, header.Name)
+		mode := header.FileInfo().Mode()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(dest, defaultPermission)
+		case tar.TypeReg, tar.TypeRegA: //nolint:staticcheck
+			err = writeFile(dest, reader, mode.Perm())
+		case tar.TypeSymlink:
+			err = symlink(dir, dest, header.Linkname)
+		default:
+			continue // devices, hard links and so on are not needed for sources
+		}
+		if err != nil {
+			return fmt.Errorf("extract %s: %w", header.Name, err)
+		}
+	}
+}
+
+func extractZip(file *os.File, size int64, dir string) error {
+	reader, err := zip.NewReader(file, size)
+	if err != nil {
+		return fmt.Errorf("open zip: %w", err)
+	}
+	for _, entry := range reader.File {
+		if err := extractZipEntry(entry, dir); err != nil {
+			return fmt.Errorf("extract %s: %w", entry.Name, err)
+		}
+	}
+	return nil
+}
+
+func extractZipEntry(entry *zip.File, dir string) error {
+	dest := destination(dir, entry.Name)
+	mode := entry.Mode()
+	if mode.IsDir() {
+		return os.MkdirAll(dest, defaultPermission)
+	}
+	src, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if mode&os.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(src)
+		if err != nil {
+			return err
+		}
+		return symlink(dir, dest, string(target))
+	}
+	if !mode.IsRegular() {
+		return nil
+	}
+	return writeFile(dest, src, mode.Perm())
+}
+
+// destination of entry inside dir. Entries can not escape dir.
+func destination(dir, name string) string {
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func writeFile(dest string, content io.Reader, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dest), defaultPermission); err != nil {
+		return err
+	}
+	if perm == 0 {
+		perm = 0644
+	}
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, content); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// symlink creates link only if target is inside dir.
+func symlink(dir, dest, target string) error {
+	resolved := target
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(filepath.Dir(dest), resolved)
+	}
+	if rel, err := filepath.Rel(dir, resolved); err != nil || strings.HasPrefix(rel, "..") || filepath.IsAbs(target) {
+		return nil // skip links outside of the archive
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), defaultPermission); err != nil {
+		return err
+	}
+	return os.Symlink(target, dest)
+}
+
+func isZip(name string) bool {
+	return strings.HasSuffix(name, ".zip")
+}
+
+func isTar(name string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
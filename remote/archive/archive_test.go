@@ -0,0 +1,181 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/reddec/git-pipe/remote"
+	"github.com/reddec/git-pipe/remote/archive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupported(t *testing.T) {
+	assert.True(t, archive.Supported("https://example.com/releases/app.tar.gz"))
+	assert.True(t, archive.Supported("http://example.com/app.zip"))
+	assert.True(t, archive.Supported("archive+https://example.com/download?id=1"))
+	assert.False(t, archive.Supported("https://github.com/example/app.git"))
+	assert.False(t, archive.Supported("git@github.com:example/app.tar.gz"))
+}
+
+func TestArchive_Poll(t *testing.T) {
+	var (
+		content   []byte
+		requests  int
+		truncated bool // emulate broken download
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+		user, password, _ := request.BasicAuth()
+		if user != "deploy" || password != "secret" {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		hash := sha256.Sum256(content)
+		etag := `"` + hex.EncodeToString(hash[:]) + `"`
+		if request.Header.Get("If-None-Match") == etag {
+			writer.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writer.Header().Set("ETag", etag)
+		if truncated {
+			_, _ = writer.Write(content[:len(content)/2])
+			return
+		}
+		_, _ = writer.Write(content)
+	}))
+	defer server.Close()
+
+	poll := func(src remote.Source, dir string) bool {
+		changed, err := src.Poll(context.Background(), dir)
+		require.NoError(t, err)
+		return changed
+	}
+	read := func(file string) string {
+		data, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("tar", func(t *testing.T) {
+		src, err := archive.New(server.URL+"/app.tar.gz", remote.Credentials{Username: "deploy", Password: "secret"})
+		require.NoError(t, err)
+		dir := filepath.Join(t.TempDir(), "app")
+
+		content = tarGz(t, map[string]string{"app-1.0/Dockerfile": "FROM alpine"})
+		assert.True(t, poll(src, dir))
+		assert.Equal(t, "FROM alpine", read(filepath.Join(dir, "Dockerfile")), "single root directory should be stripped")
+
+		requests = 0
+		assert.False(t, poll(src, dir))
+		assert.Equal(t, 1, requests)
+
+		content = tarGz(t, map[string]string{"Dockerfile": "FROM busybox", "../../escape": "oops"})
+		assert.True(t, poll(src, dir))
+		assert.Equal(t, "FROM busybox", read(filepath.Join(dir, "Dockerfile")))
+		assert.Equal(t, "oops", read(filepath.Join(dir, "escape")), "entries can not escape target directory")
+
+		version, err := src.(remote.Versioned).Version(context.Background(), dir)
+		require.NoError(t, err)
+		hash := sha256.Sum256(content)
+		assert.Equal(t, hex.EncodeToString(hash[:]), version)
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		src, err := archive.New("archive+http://deploy:secret@"+server.Listener.Addr().String()+"/download", remote.Credentials{})
+		require.NoError(t, err)
+		dir := filepath.Join(t.TempDir(), "app")
+
+		var buffer bytes.Buffer
+		writer := zip.NewWriter(&buffer)
+		file, err := writer.Create("docker-compose.yaml")
+		require.NoError(t, err)
+		_, err = file.Write([]byte("services: {}"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+		content = buffer.Bytes()
+
+		assert.True(t, poll(src, dir), "format is detected by content type")
+		assert.Equal(t, "services: {}", read(filepath.Join(dir, "docker-compose.yaml")))
+	})
+
+	t.Run("failed update is retried", func(t *testing.T) {
+		src, err := archive.New(server.URL+"/app.tar.gz", remote.Credentials{Username: "deploy", Password: "secret"})
+		require.NoError(t, err)
+		dir := filepath.Join(t.TempDir(), "app")
+
+		content = tarGz(t, map[string]string{"Dockerfile": "FROM alpine"})
+		assert.True(t, poll(src, dir))
+
+		content = tarGz(t, map[string]string{"Dockerfile": "FROM busybox"})
+		truncated = true
+		_, err = src.Poll(context.Background(), dir)
+		assert.Error(t, err)
+		assert.Equal(t, "FROM alpine", read(filepath.Join(dir, "Dockerfile")), "content should not be changed")
+
+		truncated = false
+		assert.True(t, poll(src, dir))
+		assert.Equal(t, "FROM busybox", read(filepath.Join(dir, "Dockerfile")))
+	})
+
+	t.Run("checkout", func(t *testing.T) {
+		src, err := archive.New(server.URL+"/app.tar.gz", remote.Credentials{Username: "deploy", Password: "secret"})
+		require.NoError(t, err)
+		dir := filepath.Join(t.TempDir(), "app")
+		ctx := context.Background()
+
+		content = tarGz(t, map[string]string{"Dockerfile": "FROM alpine"})
+		assert.True(t, poll(src, dir))
+		good, err := src.(remote.Versioned).Version(ctx, dir)
+		require.NoError(t, err)
+		running, err := filepath.EvalSymlinks(dir)
+		require.NoError(t, err)
+
+		content = tarGz(t, map[string]string{"Dockerfile": "FROM busybox"})
+		assert.True(t, poll(src, dir))
+		assert.Equal(t, "FROM busybox", read(filepath.Join(dir, "Dockerfile")))
+		assert.Equal(t, "FROM alpine", read(filepath.Join(running, "Dockerfile")), "content of running version is kept")
+
+		require.NoError(t, src.(remote.Checkouter).Checkout(ctx, dir, good))
+		assert.Equal(t, "FROM alpine", read(filepath.Join(dir, "Dockerfile")))
+		version, err := src.(remote.Versioned).Version(ctx, dir)
+		require.NoError(t, err)
+		assert.Equal(t, good, version)
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		src, err := archive.New(server.URL+"/app.tar.gz", remote.Credentials{})
+		require.NoError(t, err)
+		_, err = src.Poll(context.Background(), filepath.Join(t.TempDir(), "app"))
+		assert.ErrorIs(t, err, archive.ErrUnexpectedStatus)
+	})
+}
+
+func tarGz(t *testing.T, files map[string]string) []byte {
+	var buffer bytes.Buffer
+	gz := gzip.NewWriter(&buffer)
+	writer := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, writer.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := writer.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	require.NoError(t, gz.Close())
+	return buffer.Bytes()
+}
@@ -0,0 +1,3 @@
+package git
+
+var CredentialsEnv = credentialsEnv //nolint:gochecknoglobals
@@ -5,12 +5,15 @@ import (
 	"io/ioutil"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"testing"
 
+	"github.com/reddec/git-pipe/remote"
 	"github.com/reddec/git-pipe/remote/git"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestPoller(t *testing.T) {
@@ -37,3 +40,192 @@ func TestPoller(t *testing.T) {
 	assert.DirExists(t, filepath.Join(dir, ".git"))
 	assert.FileExists(t, filepath.Join(dir, "README.md"))
 }
+
+func TestParseTarget(t *testing.T) {
+	cases := []struct {
+		fragment string
+		expected git.Target
+	}{
+		{fragment: "", expected: git.Target{Branch: "master"}},
+		{fragment: "main", expected: git.Target{Branch: "main"}},
+		{fragment: "main:services/api", expected: git.Target{Branch: "main", Path: "services/api"}},
+		{fragment: ":services/api/", expected: git.Target{Branch: "master", Path: "services/api"}},
+		{fragment: "main:../../etc", expected: git.Target{Branch: "main", Path: "etc"}},
+		{fragment: "tag:v1.*:api", expected: git.Target{Tag: "v1.*", Path: "api"}},
+		{fragment: "commit:8b2d0c3f", expected: git.Target{Commit: "8b2d0c3f"}},
+	}
+	for _, c := range cases {
+		target := git.ParseTarget(c.fragment)
+		assert.Equal(t, c.expected, target, c.fragment)
+		assert.Equal(t, target, git.ParseTarget(target.String()), c.fragment)
+	}
+
+	// SCP-like URL has colon in address, which should not affect target
+	u, err := git.ParseURL("git@host:mono.git#main:services/api")
+	require.NoError(t, err)
+	assert.Equal(t, git.Target{Branch: "main", Path: "services/api"}, git.ParseTarget(u.Fragment))
+}
+
+func TestParseURL(t *testing.T) {
+	cases := []struct {
+		rawURL   string
+		host     string
+		path     string
+		fragment string
+	}{
+		{rawURL: "git@github.com:org/app.git", host: "github.com:22", path: "/org/app.git"},
+		{rawURL: "git@github.com:org/app.git#main", host: "github.com:22", path: "/org/app.git", fragment: "main"},
+		{rawURL: "git@github.com:org/app.git#tag:v1.*", host: "github.com:22", path: "/org/app.git", fragment: "tag:v1.*"},
+		{rawURL: "git@github.com:org/app.git#commit:8b2d0c3f", host: "github.com:22", path: "/org/app.git", fragment: "commit:8b2d0c3f"},
+		{rawURL: "git@github.com:2222/org/app.git#tag:v1.*:api", host: "github.com:2222", path: "/org/app.git", fragment: "tag:v1.*:api"},
+		{rawURL: "https://github.com/org/app.git#tag:v1.*", host: "github.com", path: "/org/app.git", fragment: "tag:v1.*"},
+	}
+	for _, c := range cases {
+		u, err := git.ParseURL(c.rawURL)
+		require.NoError(t, err, c.rawURL)
+		assert.Equal(t, c.host, u.Host, c.rawURL)
+		assert.Equal(t, c.path, u.Path, c.rawURL)
+		assert.Equal(t, c.fragment, u.Fragment, c.rawURL)
+	}
+
+	u, err := git.ParseURL("git@github.com:org/app.git#commit:8b2d0c3f")
+	require.NoError(t, err)
+	assert.Equal(t, git.Target{Commit: "8b2d0c3f"}, git.ParseTarget(u.Fragment))
+}
+
+func TestGit_Poll_signed(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen required")
+	}
+	keys := t.TempDir()
+	origin := t.TempDir()
+	run := func(dir string, args ...string) {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	for _, name := range []string{"allowed", "other"} {
+		run(keys, "ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", name, "-f", name)
+	}
+	pub, err := ioutil.ReadFile(filepath.Join(keys, "allowed.pub"))
+	require.NoError(t, err)
+	signers := filepath.Join(keys, "allowed_signers")
+	require.NoError(t, ioutil.WriteFile(signers, append([]byte("deploy@example.com "), pub...), 0600))
+
+	run(origin, "git", "init", "-q", "-b", "master")
+	commit := func(content string, key string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(origin, "file"), []byte(content), 0600))
+		args := []string{"git", "-c", "user.name=test", "-c", "user.email=deploy@example.com", "-c", "gpg.format=ssh"}
+		if key != "" {
+			args = append(args, "-c", "user.signingkey="+filepath.Join(keys, key), "-c", "commit.gpgsign=true")
+		}
+		run(origin, "git", "add", "file")
+		run(origin, append(args, "commit", "-q", "-m", content)...)
+	}
+	content := func(dir string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "file"))
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	commit("one", "")
+	src, dir := git.NewWithOptions(url.URL{Scheme: "file", Path: origin}, git.Options{AllowedSigners: signers}), t.TempDir()
+
+	_, err = src.Poll(context.Background(), dir)
+	assert.ErrorIs(t, err, git.ErrUntrustedCommit, "nothing trusted yet")
+
+	commit("two", "allowed")
+	changed, err := src.Poll(context.Background(), dir)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "two", content(dir))
+
+	for _, key := range []string{"other", ""} {
+		commit("three-"+key, key)
+		changed, err = src.Poll(context.Background(), dir)
+		require.NoError(t, err)
+		assert.False(t, changed, key)
+		assert.Equal(t, "two", content(dir), key)
+	}
+
+	commit("four", "allowed")
+	changed, err = src.Poll(context.Background(), dir)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "four", content(dir))
+}
+
+func TestGit_Version_subdir(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git required")
+	}
+	origin := t.TempDir()
+	run := func(args ...string) {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Dir = origin
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	commit := func(file, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(origin, file)), 0700))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(origin, file), []byte(content), 0600))
+		run("git", "add", file)
+		run("git", "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", content)
+	}
+	run("git", "init", "-q", "-b", "master")
+	commit("services/api/Dockerfile", "api v1")
+
+	src, dir := git.New(url.URL{Scheme: "file", Path: origin, Fragment: "master:services/api"}), t.TempDir()
+	content := func() string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "services", "api", "Dockerfile"))
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	_, err := src.Poll(context.Background(), dir)
+	require.NoError(t, err)
+	v1, err := src.(remote.Versioned).Version(context.Background(), dir)
+	require.NoError(t, err)
+
+	commit("services/web/Dockerfile", "web v1")
+	_, err = src.Poll(context.Background(), dir)
+	require.NoError(t, err)
+	version, err := src.(remote.Versioned).Version(context.Background(), dir)
+	require.NoError(t, err)
+	assert.Equal(t, v1, version, "version depends only on subdirectory")
+
+	commit("services/api/Dockerfile", "api v2")
+	_, err = src.Poll(context.Background(), dir)
+	require.NoError(t, err)
+	v2, err := src.(remote.Versioned).Version(context.Background(), dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, v1, v2)
+	assert.Equal(t, "api v2", content())
+
+	require.NoError(t, src.(remote.Checkouter).Checkout(context.Background(), dir, v1))
+	assert.Equal(t, "api v1", content())
+}
+
+func TestCredentialsEnv(t *testing.T) {
+	u, err := git.ParseURL("https://user@git.example.com:8443/team/app.git#main:services/api")
+	require.NoError(t, err)
+
+	env := git.CredentialsEnv(*u, remote.Credentials{Password: "token"})
+	assert.Equal(t, "1", env["GIT_CONFIG_COUNT"])
+	assert.Equal(t, "http.https://git.example.com:8443/team/app.git.extraHeader", env["GIT_CONFIG_KEY_0"])
+	assert.Equal(t, "Authorization: Basic Z2l0OnRva2Vu", env["GIT_CONFIG_VALUE_0"]) // git:token
+	assert.Equal(t, "0", env["GIT_TERMINAL_PROMPT"])
+
+	env = git.CredentialsEnv(*u, remote.Credentials{Username: "deploy", Password: "token"})
+	assert.Equal(t, "Authorization: Basic ZGVwbG95OnRva2Vu", env["GIT_CONFIG_VALUE_0"]) // deploy:token
+
+	ssh, err := git.ParseURL("git@github.com:example/app.git")
+	require.NoError(t, err)
+	env = git.CredentialsEnv(*ssh, remote.Credentials{Password: "token", SSHKey: "/keys/it's", KnownHosts: "/keys/known_hosts"})
+	assert.NotContains(t, env, "GIT_CONFIG_KEY_0", "HTTP credentials are not used for SSH")
+	assert.Equal(t, `ssh -i '/keys/it'\''s' -o IdentitiesOnly=yes -o 'UserKnownHostsFile=/keys/known_hosts' -o StrictHostKeyChecking=yes`,
+		env["GIT_SSH_COMMAND"])
+
+	assert.Empty(t, git.CredentialsEnv(*u, remote.Credentials{}))
+}
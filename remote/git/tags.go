@@ -0,0 +1,95 @@
+package git
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// SelectTag returns the highest semantic version tag matched by glob pattern (ex: v1.*).
+// Tags which are not semantic versions are ignored. Returns false if nothing matched.
+func SelectTag(tags []string, pattern string) (string, bool) {
+	var (
+		best    string
+		bestVer semver
+		found   bool
+	)
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if ok, err := path.Match(pattern, tag); err != nil || !ok {
+			continue
+		}
+		ver, ok := parseSemver(tag)
+		if !ok {
+			continue
+		}
+		if !found || bestVer.less(ver) {
+			best, bestVer, found = tag, ver, true
+		}
+	}
+	return best, found
+}
+
+type semver struct {
+	numbers    [3]uint64
+	prerelease []string
+}
+
+// parseSemver parses [v]MAJOR[.MINOR[.PATCH]][-PRERELEASE][+BUILD].
+func parseSemver(text string) (semver, bool) {
+	var ver semver
+	text = strings.TrimPrefix(text, "v")
+	if idx := strings.IndexByte(text, '+'); idx >= 0 {
+		text = text[:idx]
+	}
+	if idx := strings.IndexByte(text, '-'); idx >= 0 {
+		ver.prerelease = strings.Split(text[idx+1:], ".")
+		text = text[:idx]
+	}
+	parts := strings.Split(text, ".")
+	if len(parts) > len(ver.numbers) {
+		return ver, false
+	}
+	for i, part := range parts {
+		v, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return ver, false
+		}
+		ver.numbers[i] = v
+	}
+	return ver, true
+}
+
+func (sv semver) less(other semver) bool {
+	for i := range sv.numbers {
+		if sv.numbers[i] != other.numbers[i] {
+			return sv.numbers[i] < other.numbers[i]
+		}
+	}
+	// release is higher than pre-release
+	switch {
+	case len(sv.prerelease) == 0:
+		return false
+	case len(other.prerelease) == 0:
+		return true
+	}
+	for i := 0; i < len(sv.prerelease) && i < len(other.prerelease); i++ {
+		a, b := sv.prerelease[i], other.prerelease[i]
+		if a == b {
+			continue
+		}
+		na, errA := strconv.ParseUint(a, 10, 64)
+		nb, errB := strconv.ParseUint(b, 10, 64)
+		switch {
+		case errA == nil && errB == nil:
+			return na < nb
+		case errA == nil:
+			return true // numeric identifiers have lower precedence
+		case errB == nil:
+			return false
+		default:
+			return a < b
+		}
+	}
+	return len(sv.prerelease) < len(other.prerelease)
+}
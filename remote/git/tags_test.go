@@ -0,0 +1,37 @@
+package git_test
+
+import (
+	"testing"
+
+	"github.com/reddec/git-pipe/remote/git"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectTag(t *testing.T) {
+	tags := []string{"v1.2.0", "v1.10.0", "v1.9.3", "v2.0.0", "v1.11.0-rc.1", "v1.11.0-rc.2", "latest", "v1.x", "1.12"}
+
+	cases := []struct {
+		pattern  string
+		expected string
+		found    bool
+	}{
+		{pattern: "v1.*", expected: "v1.11.0-rc.2", found: true},
+		{pattern: "v1.9.*", expected: "v1.9.3", found: true},
+		{pattern: "v*", expected: "v2.0.0", found: true},
+		{pattern: "*", expected: "v2.0.0", found: true},
+		{pattern: "1.*", expected: "1.12", found: true},
+		{pattern: "v3.*", found: false},
+		{pattern: "latest", found: false},
+	}
+
+	for _, c := range cases {
+		tag, found := git.SelectTag(tags, c.pattern)
+		assert.Equal(t, c.found, found, c.pattern)
+		assert.Equal(t, c.expected, tag, c.pattern)
+	}
+
+	tag, _ := git.SelectTag([]string{"v1.0.0-rc.1", "v1.0.0", "v1.0.0-beta"}, "v1.*")
+	assert.Equal(t, "v1.0.0", tag)
+	tag, _ = git.SelectTag([]string{"v1.0.0-rc.2", "v1.0.0-rc.10", "v1.0.0-beta"}, "v1.*")
+	assert.Equal(t, "v1.0.0-rc.10", tag)
+}
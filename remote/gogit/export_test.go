@@ -0,0 +1,3 @@
+package gogit
+
+var HostKeyCallback = hostKeyCallback //nolint:gochecknoglobals
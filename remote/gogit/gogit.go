@@ -0,0 +1,470 @@
+// Package gogit implements git source by in-process git library, so git binary is not required.
+package gogit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/reddec/git-pipe/internal"
+	"github.com/reddec/git-pipe/remote"
+	gitcli "github.com/reddec/git-pipe/remote/git"
+	"go.uber.org/zap"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	remoteName  = "origin"
+	defaultUser = "git"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// New native git source. URL fragment has the same meaning as for git source (see git.ParseTarget).
+func New(u url.URL, credentials remote.Credentials) remote.Source {
+	return NewWithOptions(u, gitcli.Options{Credentials: credentials})
+}
+
+// NewWithOptions creates native git source with custom options. LFS is not supported and ignored.
+func NewWithOptions(u url.URL, options gitcli.Options) remote.Source {
+	plain := u
+	plain.Fragment = ""
+	plain.User = nil
+	return &Git{
+		rawURL:      plain.String(),
+		url:         u,
+		target:      gitcli.ParseTarget(u.Fragment),
+		credentials: options.Credentials,
+		submodules:  options.Submodules,
+		depth:       options.Depth,
+	}
+}
+
+func FromURL(rawURL string, credentials remote.Credentials) (remote.Source, error) {
+	u, err := gitcli.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return New(*u, credentials), nil
+}
+
+type Git struct {
+	rawURL      string
+	url         url.URL
+	target      gitcli.Target
+	credentials remote.Credentials
+	submodules  bool
+	depth       int               // zero means full history
+	commits     map[string]string // version of subdirectory (tree hash) -> commit with it
+}
+
+func (gg *Git) Ref() url.URL {
+	return gg.url
+}
+
+// Subdir to deploy. Empty means repo root.
+func (gg *Git) Subdir() string {
+	return gg.target.Path
+}
+
+func (gg *Git) Poll(ctx context.Context, targetDir string) (bool, error) {
+	auth, err := gg.auth()
+	if err != nil {
+		return false, fmt.Errorf("prepare auth: %w", err)
+	}
+
+	repo, err := git.PlainOpen(targetDir)
+	fresh := errors.Is(err, git.ErrRepositoryNotExists)
+	internal.LoggerFromContext(ctx).Debug("cloning git repository", zap.String("target_dir", targetDir), zap.Bool("fresh", fresh))
+	switch {
+	case fresh:
+		repo, err = gg.clone(ctx, targetDir, auth)
+	case err != nil:
+		return false, fmt.Errorf("open repo: %w", err)
+	default:
+		err = gg.fetch(ctx, repo, auth)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	prevState, err := gg.state(repo)
+	if err != nil {
+		return false, err
+	}
+
+	newHash, err := gg.revision(ctx, repo)
+	if err != nil {
+		return false, err
+	}
+
+	if err := gg.reset(ctx, repo, newHash, auth); err != nil {
+		return false, err
+	}
+
+	newState, err := gg.state(repo)
+	if err != nil {
+		return false, err
+	}
+
+	return fresh || prevState != newState, nil
+}
+
+// Version is a current commit hash. For subdirectory it is hash of subdirectory tree, so commits outside of it have
+// the same version.
+func (gg *Git) Version(_ context.Context, targetDir string) (string, error) {
+	repo, err := git.PlainOpen(targetDir)
+	if err != nil {
+		return "", fmt.Errorf("open repo: %w", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", fmt.Errorf("get head: %w", err)
+	}
+	if gg.target.Path == "" {
+		return head.Hash().String(), nil
+	}
+	tree, err := subdirHash(repo, head.Hash(), gg.target.Path)
+	if err != nil {
+		return "", err
+	}
+	if tree == "" {
+		return "", fmt.Errorf("find %s: %w", gg.target.Path, object.ErrDirectoryNotFound)
+	}
+	if gg.commits == nil {
+		gg.commits = make(map[string]string)
+	}
+	gg.commits[tree] = head.Hash().String()
+	return tree, nil
+}
+
+// Checkout previously polled version.
+func (gg *Git) Checkout(ctx context.Context, targetDir string, version string) error {
+	if commit, ok := gg.commits[version]; ok {
+		version = commit
+	}
+	auth, err := gg.auth()
+	if err != nil {
+		return fmt.Errorf("prepare auth: %w", err)
+	}
+	repo, err := git.PlainOpen(targetDir)
+	if err != nil {
+		return fmt.Errorf("open repo: %w", err)
+	}
+	return gg.reset(ctx, repo, plumbing.NewHash(version), auth)
+}
+
+func (gg *Git) clone(ctx context.Context, targetDir string, auth transport.AuthMethod) (*git.Repository, error) {
+	opts := &git.CloneOptions{
+		URL:        gg.rawURL,
+		Auth:       auth,
+		NoCheckout: true,
+		Depth:      gg.fetchDepth(),
+	}
+	if gg.target.Branch != "" {
+		opts.ReferenceName = plumbing.NewBranchReferenceName(gg.target.Branch)
+		opts.SingleBranch = true
+		opts.Tags = git.NoTags
+	} else {
+		// tags and commits could be anywhere in history
+		opts.Tags = git.AllTags
+	}
+	repo, err := git.PlainCloneContext(ctx, targetDir, false, opts)
+	if err != nil {
+		return nil, fmt.Errorf("clone: %w", err)
+	}
+	return repo, nil
+}
+
+func (gg *Git) fetch(ctx context.Context, repo *git.Repository, auth transport.AuthMethod) error {
+	opts := &git.FetchOptions{
+		RemoteName: remoteName,
+		Auth:       auth,
+		Force:      true,
+		Depth:      gg.fetchDepth(),
+	}
+	switch {
+	case gg.target.Tag != "":
+		opts.RefSpecs = []config.RefSpec{"+refs/tags/*:refs/tags/*"}
+		opts.Tags = git.AllTags
+	case gg.target.Commit != "":
+		if _, err := repo.ResolveRevision(plumbing.Revision(gg.target.Commit)); err == nil {
+			return nil // commits are immutable
+		}
+		opts.RefSpecs = []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*"}
+		opts.Tags = git.AllTags
+	default:
+		opts.RefSpecs = []config.RefSpec{config.RefSpec("+refs/heads/" + gg.target.Branch + ":refs/remotes/origin/" + gg.target.Branch)}
+		opts.Tags = git.NoTags
+	}
+	origin, err := repo.Remote(remoteName)
+	if err != nil {
+		return fmt.Errorf("get remote: %w", err)
+	}
+	if opts.Depth > 0 {
+		origin, err = shallowRemote(ctx, repo, origin, auth)
+		if err != nil {
+			return err
+		}
+	}
+	err = origin.FetchContext(ctx, opts)
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	return nil
+}
+
+// fetchDepth of history. Commits could be anywhere in history, so they are always fetched with full history.
+func (gg *Git) fetchDepth() int {
+	if gg.target.Commit != "" {
+		return 0
+	}
+	return gg.depth
+}
+
+// shallowRemote hides local references to commits which are not advertised by remote anymore. During fetch go-git
+// walks history of such references to find common commits and fails on missing parents of shallow repository.
+func shallowRemote(ctx context.Context, repo *git.Repository, origin *git.Remote, auth transport.AuthMethod) (*git.Remote, error) {
+	refs, err := origin.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return nil, fmt.Errorf("list remote references: %w", err)
+	}
+	advertised := make(map[plumbing.Hash]bool, len(refs))
+	for _, ref := range refs {
+		advertised[ref.Hash()] = true
+	}
+	return git.NewRemote(&advertisedStorer{Storer: repo.Storer, advertised: advertised}, origin.Config()), nil
+}
+
+// advertisedStorer lists only symbolic references and references to advertised commits.
+type advertisedStorer struct {
+	storage.Storer
+	advertised map[plumbing.Hash]bool
+}
+
+func (as *advertisedStorer) IterReferences() (storer.ReferenceIter, error) {
+	refs, err := as.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	return storer.NewReferenceFilteredIter(func(ref *plumbing.Reference) bool {
+		return ref.Type() != plumbing.HashReference || as.advertised[ref.Hash()]
+	}, refs), nil
+}
+
+// revision (commit) to deploy.
+func (gg *Git) revision(ctx context.Context, repo *git.Repository) (plumbing.Hash, error) {
+	switch {
+	case gg.target.Tag != "":
+		tags, err := repo.Tags()
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("list tags: %w", err)
+		}
+		var names []string
+		_ = tags.ForEach(func(ref *plumbing.Reference) error {
+			names = append(names, ref.Name().Short())
+			return nil
+		})
+		tag, ok := gitcli.SelectTag(names, gg.target.Tag)
+		if !ok {
+			return plumbing.ZeroHash, fmt.Errorf("%s: %w", gg.target.Tag, gitcli.ErrNoMatchingTag)
+		}
+		internal.LoggerFromContext(ctx).Debug("tag selected", zap.String("tag", tag))
+		ref, err := repo.Tag(tag)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("get tag %s: %w", tag, err)
+		}
+		return peel(repo, ref.Hash())
+	case gg.target.Commit != "":
+		hash, err := repo.ResolveRevision(plumbing.Revision(gg.target.Commit))
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("%s: %w", gg.target.Commit, ErrRevisionNotFound)
+		}
+		return *hash, nil
+	default:
+		ref, err := repo.Reference(plumbing.NewRemoteReferenceName(remoteName, gg.target.Branch), true)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("branch %s: %w", gg.target.Branch, err)
+		}
+		return ref.Hash(), nil
+	}
+}
+
+// auth method based on URL scheme and credentials. Returns nil if no auth needed.
+func (gg *Git) auth() (transport.AuthMethod, error) {
+	creds := gg.credentials
+	switch gg.url.Scheme {
+	case "ssh":
+		user := gg.url.User.Username()
+		if user == "" {
+			user = defaultUser
+		}
+		hostKeys, err := hostKeyCallback(creds)
+		if err != nil {
+			return nil, err
+		}
+		if creds.SSHKey != "" {
+			keys, err := ssh.NewPublicKeysFromFile(user, creds.SSHKey, creds.SSHKeyPassword)
+			if err != nil {
+				return nil, fmt.Errorf("load SSH key: %w", err)
+			}
+			keys.HostKeyCallback = hostKeys
+			return keys, nil
+		}
+		agent, err := ssh.NewSSHAgentAuth(user)
+		if err != nil {
+			return nil, fmt.Errorf("connect to SSH agent: %w", err)
+		}
+		agent.HostKeyCallback = hostKeys
+		return agent, nil
+	case "http", "https":
+		username, password := creds.Username, creds.Password
+		if password == "" && gg.url.User != nil {
+			username = gg.url.User.Username()
+			password, _ = gg.url.User.Password()
+		}
+		if password == "" {
+			return nil, nil
+		}
+		if username == "" {
+			username = defaultUser // tokens usually accept any non-empty user
+		}
+		return &http.BasicAuth{Username: username, Password: password}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// hostKeyCallback verifies host keys by known_hosts file from credentials or by default known hosts files
+// (SSH_KNOWN_HOSTS, ~/.ssh/known_hosts, /etc/ssh/ssh_known_hosts). Verification could be disabled explicitly only.
+func hostKeyCallback(creds remote.Credentials) (gossh.HostKeyCallback, error) {
+	if creds.InsecureIgnoreHostKey {
+		return gossh.InsecureIgnoreHostKey(), nil //nolint:gosec
+	}
+	var files []string
+	if creds.KnownHosts != "" {
+		files = append(files, creds.KnownHosts)
+	}
+	cb, err := ssh.NewKnownHostsCallback(files...)
+	if err != nil {
+		return nil, fmt.Errorf("load known hosts: %w", err)
+	}
+	return cb, nil
+}
+
+// peel annotated tag to commit.
+func peel(repo *git.Repository, hash plumbing.Hash) (plumbing.Hash, error) {
+	tag, err := repo.TagObject(hash)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return hash, nil // lightweight tag
+	}
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("get tag object: %w", err)
+	}
+	commit, err := tag.Commit()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("get tagged commit: %w", err)
+	}
+	return commit.Hash, nil
+}
+
+func (gg *Git) reset(ctx context.Context, repo *git.Repository, hash plumbing.Hash, auth transport.AuthMethod) error {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("get worktree: %w", err)
+	}
+	if err := worktree.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset}); err != nil {
+		return fmt.Errorf("reset to %s: %w", hash, err)
+	}
+	if !gg.submodules {
+		return nil
+	}
+	submodules, err := worktree.Submodules()
+	if err != nil {
+		return fmt.Errorf("get submodules: %w", err)
+	}
+	err = submodules.UpdateContext(ctx, &git.SubmoduleUpdateOptions{
+		Init:              true,
+		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+		Auth:              auth,
+	})
+	if err != nil {
+		return fmt.Errorf("update submodules: %w", err)
+	}
+	return nil
+}
+
+// state of working tree: current commit (or tree of subdirectory) and commits of all submodules (if enabled).
+func (gg *Git) state(repo *git.Repository) (string, error) {
+	head, err := repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return "", nil // nothing checked out yet
+	}
+	if err != nil {
+		return "", fmt.Errorf("get head: %w", err)
+	}
+	state := head.Hash().String()
+	if gg.target.Path != "" {
+		state, err = subdirHash(repo, head.Hash(), gg.target.Path)
+		if err != nil {
+			return "", err
+		}
+	}
+	if !gg.submodules {
+		return state, nil
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("get worktree: %w", err)
+	}
+	submodules, err := worktree.Submodules()
+	if err != nil {
+		return "", fmt.Errorf("get submodules: %w", err)
+	}
+	status, err := submodules.Status()
+	if err != nil {
+		return "", fmt.Errorf("get submodules status: %w", err)
+	}
+	for _, sub := range status {
+		if inside(sub.Path, gg.target.Path) {
+			state += "\n" + sub.String()
+		}
+	}
+	return state, nil
+}
+
+// subdirHash is hash of subdirectory tree in the commit. Empty if subdirectory does not exist.
+func subdirHash(repo *git.Repository, hash plumbing.Hash, dir string) (string, error) {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return "", fmt.Errorf("get commit: %w", err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", fmt.Errorf("get tree: %w", err)
+	}
+	entry, err := tree.FindEntry(dir)
+	if errors.Is(err, object.ErrDirectoryNotFound) || errors.Is(err, object.ErrEntryNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("find %s: %w", dir, err)
+	}
+	return entry.Hash.String(), nil
+}
+
+// inside returns true if file is inside dir. Empty dir means repo root.
+func inside(file, dir string) bool {
+	return dir == "" || file == dir || strings.HasPrefix(file, dir+"/")
+}
@@ -0,0 +1,370 @@
+package gogit_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/reddec/git-pipe/remote"
+	gitcli "github.com/reddec/git-pipe/remote/git"
+	"github.com/reddec/git-pipe/remote/gogit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestGit_Poll(t *testing.T) {
+	origin := t.TempDir()
+	repo, err := git.PlainInit(origin, false)
+	require.NoError(t, err)
+
+	commit := func(content string, tag string) string {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(origin, "file"), []byte(content), 0600))
+		worktree, err := repo.Worktree()
+		require.NoError(t, err)
+		_, err = worktree.Add("file")
+		require.NoError(t, err)
+		hash, err := worktree.Commit(content, &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+		if tag != "" {
+			_, err = repo.CreateTag(tag, hash, nil)
+			require.NoError(t, err)
+		}
+		return hash.String()
+	}
+
+	poll := func(source remote.Source, dir string) (bool, string) {
+		changed, err := source.Poll(context.Background(), dir)
+		require.NoError(t, err)
+		data, err := ioutil.ReadFile(filepath.Join(dir, "file"))
+		require.NoError(t, err)
+		return changed, string(data)
+	}
+
+	first := commit("one", "v1.0.0")
+	commit("two", "v1.1.0")
+	commit("three", "v2.0.0")
+
+	source := func(fragment string) remote.Source {
+		return gogit.New(url.URL{Scheme: "file", Path: origin, Fragment: fragment}, remote.Credentials{})
+	}
+
+	t.Run("branch", func(t *testing.T) {
+		src, dir := source("master"), t.TempDir()
+		changed, content := poll(src, dir)
+		assert.True(t, changed)
+		assert.Equal(t, "three", content)
+
+		changed, _ = poll(src, dir)
+		assert.False(t, changed)
+
+		previous, err := src.(remote.Versioned).Version(context.Background(), dir)
+		require.NoError(t, err)
+
+		latest := commit("four", "")
+		changed, content = poll(src, dir)
+		assert.True(t, changed)
+		assert.Equal(t, "four", content)
+
+		// roll back to previously polled version
+		require.NoError(t, src.(remote.Checkouter).Checkout(context.Background(), dir, previous))
+		data, err := ioutil.ReadFile(filepath.Join(dir, "file"))
+		require.NoError(t, err)
+		assert.Equal(t, "three", string(data))
+
+		changed, content = poll(src, dir)
+		assert.True(t, changed)
+		assert.Equal(t, "four", content)
+		current, err := src.(remote.Versioned).Version(context.Background(), dir)
+		require.NoError(t, err)
+		assert.Equal(t, latest, current)
+	})
+
+	t.Run("tag", func(t *testing.T) {
+		changed, content := poll(source("tag:v1.*"), t.TempDir())
+		assert.True(t, changed)
+		assert.Equal(t, "two", content)
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		changed, content := poll(source("commit:"+first[:8]), t.TempDir())
+		assert.True(t, changed)
+		assert.Equal(t, "one", content)
+	})
+}
+
+func TestGit_Poll_submodules(t *testing.T) {
+	// options are modified by commit, so they should not be shared
+	signature := func() *git.CommitOptions {
+		return &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		}
+	}
+
+	subOrigin := t.TempDir()
+	subRepo, err := git.PlainInit(subOrigin, false)
+	require.NoError(t, err)
+	subCommit := func(content string) plumbing.Hash {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(subOrigin, "file"), []byte(content), 0600))
+		worktree, err := subRepo.Worktree()
+		require.NoError(t, err)
+		_, err = worktree.Add("file")
+		require.NoError(t, err)
+		hash, err := worktree.Commit(content, signature())
+		require.NoError(t, err)
+		return hash
+	}
+
+	origin := t.TempDir()
+	repo, err := git.PlainInit(origin, false)
+	require.NoError(t, err)
+	gitmodules := "[submodule \"sub\"]\n\tpath = sub\n\turl = " + subOrigin + "\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(origin, ".gitmodules"), []byte(gitmodules), 0600))
+	// point submodule to the commit
+	link := func(hash plumbing.Hash) {
+		idx, err := repo.Storer.Index()
+		require.NoError(t, err)
+		if _, err := idx.Remove("sub"); err != nil {
+			require.ErrorIs(t, err, index.ErrEntryNotFound)
+		}
+		entry := idx.Add("sub")
+		entry.Hash = hash
+		entry.Mode = filemode.Submodule
+		require.NoError(t, repo.Storer.SetIndex(idx))
+		worktree, err := repo.Worktree()
+		require.NoError(t, err)
+		_, err = worktree.Add(".gitmodules")
+		require.NoError(t, err)
+		_, err = worktree.Commit("update submodule", signature())
+		require.NoError(t, err)
+	}
+
+	link(subCommit("one"))
+
+	src, dir := gogit.NewWithOptions(url.URL{Scheme: "file", Path: origin, Fragment: "master"}, gitcli.Options{Submodules: true}), t.TempDir()
+	poll := func() (bool, string) {
+		changed, err := src.Poll(context.Background(), dir)
+		require.NoError(t, err)
+		data, err := ioutil.ReadFile(filepath.Join(dir, "sub", "file"))
+		require.NoError(t, err)
+		return changed, string(data)
+	}
+
+	changed, content := poll()
+	assert.True(t, changed)
+	assert.Equal(t, "one", content)
+
+	changed, _ = poll()
+	assert.False(t, changed)
+
+	link(subCommit("two"))
+	changed, content = poll()
+	assert.True(t, changed)
+	assert.Equal(t, "two", content)
+}
+
+func TestGit_Poll_subdir(t *testing.T) {
+	origin := t.TempDir()
+	repo, err := git.PlainInit(origin, false)
+	require.NoError(t, err)
+
+	commit := func(file, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(origin, file)), 0700))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(origin, file), []byte(content), 0600))
+		worktree, err := repo.Worktree()
+		require.NoError(t, err)
+		_, err = worktree.Add(file)
+		require.NoError(t, err)
+		_, err = worktree.Commit(content, &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+	}
+
+	commit("services/api/Dockerfile", "api v1")
+	commit("services/web/Dockerfile", "web v1")
+
+	src, dir := gogit.New(url.URL{Scheme: "file", Path: origin, Fragment: "master:services/api"}, remote.Credentials{}), t.TempDir()
+	assert.Equal(t, "services/api", src.(remote.Scoped).Subdir())
+
+	content := func() string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "services", "api", "Dockerfile"))
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	changed, err := src.Poll(context.Background(), dir)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	v1, err := src.(remote.Versioned).Version(context.Background(), dir)
+	require.NoError(t, err)
+
+	// change outside of subdirectory
+	commit("services/web/Dockerfile", "web v2")
+	changed, err = src.Poll(context.Background(), dir)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	version, err := src.(remote.Versioned).Version(context.Background(), dir)
+	require.NoError(t, err)
+	assert.Equal(t, v1, version, "version depends only on subdirectory")
+
+	commit("services/api/Dockerfile", "api v2")
+	changed, err = src.Poll(context.Background(), dir)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "api v2", content())
+	v2, err := src.(remote.Versioned).Version(context.Background(), dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, v1, v2)
+
+	require.NoError(t, src.(remote.Checkouter).Checkout(context.Background(), dir, v1))
+	assert.Equal(t, "api v1", content())
+	version, err = src.(remote.Versioned).Version(context.Background(), dir)
+	require.NoError(t, err)
+	assert.Equal(t, v1, version)
+}
+
+func TestGit_Poll_shallow(t *testing.T) {
+	origin := t.TempDir()
+	repo, err := git.PlainInit(origin, false)
+	require.NoError(t, err)
+
+	commit := func(file, content string, tag string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(origin, file)), 0700))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(origin, file), []byte(content), 0600))
+		worktree, err := repo.Worktree()
+		require.NoError(t, err)
+		_, err = worktree.Add(file)
+		require.NoError(t, err)
+		hash, err := worktree.Commit(content, &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+		if tag != "" {
+			_, err = repo.CreateTag(tag, hash, nil)
+			require.NoError(t, err)
+		}
+	}
+	read := func(dir, file string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file))
+		require.NoError(t, err)
+		return string(data)
+	}
+	shallow := func(dir string) bool {
+		local, err := git.PlainOpen(dir)
+		require.NoError(t, err)
+		commits, err := local.Storer.Shallow()
+		require.NoError(t, err)
+		return len(commits) > 0
+	}
+
+	commit("services/api/Dockerfile", "api v1", "v1.0.0")
+	commit("services/web/Dockerfile", "web v1", "")
+	commit("services/api/Dockerfile", "api v2", "v1.1.0")
+
+	source := func(fragment string) remote.Source {
+		return gogit.NewWithOptions(url.URL{Scheme: "file", Path: origin, Fragment: fragment}, gitcli.Options{Depth: 1})
+	}
+
+	t.Run("branch", func(t *testing.T) {
+		src, dir := source("master:services/api"), t.TempDir()
+		changed, err := src.Poll(context.Background(), dir)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.True(t, shallow(dir))
+		assert.Equal(t, "api v2", read(dir, "services/api/Dockerfile"))
+		previous, err := src.(remote.Versioned).Version(context.Background(), dir)
+		require.NoError(t, err)
+
+		// change outside of subdirectory
+		commit("services/web/Dockerfile", "web v2", "")
+		changed, err = src.Poll(context.Background(), dir)
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Equal(t, "web v2", read(dir, "services/web/Dockerfile"))
+
+		commit("services/api/Dockerfile", "api v3", "")
+		changed, err = src.Poll(context.Background(), dir)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, "api v3", read(dir, "services/api/Dockerfile"))
+
+		// previously deployed commit is kept locally
+		require.NoError(t, src.(remote.Checkouter).Checkout(context.Background(), dir, previous))
+		assert.Equal(t, "api v2", read(dir, "services/api/Dockerfile"))
+	})
+
+	t.Run("tag", func(t *testing.T) {
+		src, dir := source("tag:v1.*"), t.TempDir()
+		changed, err := src.Poll(context.Background(), dir)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.True(t, shallow(dir))
+		assert.Equal(t, "api v2", read(dir, "services/api/Dockerfile"))
+
+		commit("services/api/Dockerfile", "api v4", "v1.2.0")
+		changed, err = src.Poll(context.Background(), dir)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, "api v4", read(dir, "services/api/Dockerfile"))
+	})
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	known, unknown := hostKey(t), hostKey(t)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, ioutil.WriteFile(knownHosts, []byte(knownhosts.Line([]string{"example.com"}, known)+"\n"), 0600))
+	address := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+
+	t.Run("known hosts file", func(t *testing.T) {
+		cb, err := gogit.HostKeyCallback(remote.Credentials{KnownHosts: knownHosts})
+		require.NoError(t, err)
+		assert.NoError(t, cb("example.com:22", address, known))
+		assert.Error(t, cb("example.com:22", address, unknown))
+		assert.Error(t, cb("other.example.com:22", address, known))
+	})
+
+	t.Run("default known hosts", func(t *testing.T) {
+		require.NoError(t, os.Setenv("SSH_KNOWN_HOSTS", knownHosts))
+		defer os.Unsetenv("SSH_KNOWN_HOSTS")
+
+		cb, err := gogit.HostKeyCallback(remote.Credentials{})
+		require.NoError(t, err)
+		assert.NoError(t, cb("example.com:22", address, known))
+		assert.Error(t, cb("example.com:22", address, unknown), "host keys are verified without explicit known hosts")
+	})
+
+	t.Run("missing known hosts", func(t *testing.T) {
+		_, err := gogit.HostKeyCallback(remote.Credentials{KnownHosts: filepath.Join(t.TempDir(), "missing")})
+		assert.Error(t, err)
+	})
+
+	t.Run("explicitly insecure", func(t *testing.T) {
+		cb, err := gogit.HostKeyCallback(remote.Credentials{InsecureIgnoreHostKey: true})
+		require.NoError(t, err)
+		assert.NoError(t, cb("example.com:22", address, unknown))
+	})
+}
+
+func hostKey(t *testing.T) gossh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := gossh.NewPublicKey(public)
+	require.NoError(t, err)
+	return key
+}
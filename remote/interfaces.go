@@ -12,3 +12,32 @@ type Source interface {
 	// Poll repository for changes. Should return true without error if something changed.
 	Poll(ctx context.Context, targetDir string) (bool, error)
 }
+
+// Versioned source is able to report version of polled content (ex: commit hash).
+type Versioned interface {
+	// Version of content in target directory.
+	Version(ctx context.Context, targetDir string) (string, error)
+}
+
+// Checkouter source is able to switch content in target directory to previously polled version.
+type Checkouter interface {
+	// Checkout version (as returned by Versioned) to target directory.
+	Checkout(ctx context.Context, targetDir string, version string) error
+}
+
+// Scoped source deploys only part of the repository (ex: one service from monorepo).
+type Scoped interface {
+	// Subdir of target directory with content to deploy. Changes outside it are ignored by Poll.
+	Subdir() string
+}
+
+// Credentials for private remotes. Empty fields are ignored.
+type Credentials struct {
+	SSHKey         string // path to SSH private key
+	SSHKeyPassword string // passphrase for SSH private key
+	KnownHosts     string // path to known_hosts file, empty means default known hosts (SSH_KNOWN_HOSTS or ~/.ssh)
+	Username       string // username for HTTP(S) remotes
+	Password       string // password or token for HTTP(S) remotes
+
+	InsecureIgnoreHostKey bool // skip host key verification (native driver only)
+}
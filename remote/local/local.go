@@ -0,0 +1,218 @@
+// Package local implements source which watches local directory. Useful for development: packages could be tested
+// without committing and pushing each change.
+package local
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/reddec/git-pipe/remote"
+	"github.com/reddec/git-pipe/remote/snapshot"
+)
+
+// Scheme of directory URL (ex: dir:///home/user/app).
+const Scheme = "dir"
+
+// Supported checks that URL points to local directory: dir:// scheme or file:// scheme for directory
+// which is not a git repository.
+func Supported(rawURL string) bool {
+	switch {
+	case strings.HasPrefix(rawURL, Scheme+"://"):
+		return true
+	case strings.HasPrefix(rawURL, "file://"):
+		dir := strings.TrimPrefix(rawURL, "file://")
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return false
+		}
+		for _, marker := range []string{".git", "HEAD"} {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// New local directory source.
+func New(rawURL string) (remote.Source, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+	dir, err := filepath.Abs(filepath.FromSlash(u.Path))
+	if err != nil {
+		return nil, fmt.Errorf("resolve path: %w", err)
+	}
+	return &Directory{url: *u, dir: dir}, nil
+}
+
+// Directory source. Changes are detected by hash of all files (names, modes and content),
+// .git directory is ignored. Content is copied to own snapshot for each version, so the source could be edited while
+// package is running and the last versions could be checked out again.
+type Directory struct {
+	url     url.URL
+	dir     string
+	version string // hash of the last copied content
+}
+
+func (ld *Directory) Ref() url.URL {
+	return ld.url
+}
+
+func (ld *Directory) Poll(_ context.Context, targetDir string) (bool, error) {
+	files, err := ld.files()
+	if err != nil {
+		return false, err
+	}
+	version, err := ld.hash(files)
+	if err != nil {
+		return false, err
+	}
+	if version == ld.version {
+		return false, nil
+	}
+	if err := ld.copy(files, targetDir, version); err != nil {
+		return false, err
+	}
+	ld.version = version
+	return true, nil
+}
+
+// Version is hash of content in target dir.
+func (ld *Directory) Version(_ context.Context, targetDir string) (string, error) {
+	return snapshot.Current(targetDir)
+}
+
+// Checkout one of the last versions to target dir.
+func (ld *Directory) Checkout(_ context.Context, targetDir string, version string) error {
+	return snapshot.Checkout(targetDir, version)
+}
+
+// files in the source directory relative to it, sorted.
+func (ld *Directory) files() ([]string, error) {
+	var files []string
+	err := filepath.Walk(ld.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if path == ld.dir {
+			return nil
+		}
+		rel, err := filepath.Rel(ld.dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list files: %w", err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (ld *Directory) hash(files []string) (string, error) {
+	hash := sha256.New()
+	for _, name := range files {
+		path := filepath.Join(ld.dir, name)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return "", fmt.Errorf("stat %s: %w", name, err)
+		}
+		_, _ = fmt.Fprintf(hash, "%s\x00%o\x00", filepath.ToSlash(name), info.Mode())
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return "", fmt.Errorf("read link %s: %w", name, err)
+			}
+			_, _ = io.WriteString(hash, target)
+		case info.Mode().IsRegular():
+			if err := hashFile(hash, path); err != nil {
+				return "", fmt.Errorf("read %s: %w", name, err)
+			}
+		}
+		_, _ = hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// copy files as new version of target directory.
+func (ld *Directory) copy(files []string, targetDir string, version string) error {
+	tmpDir, err := ioutil.TempDir(filepath.Dir(targetDir), ".copy-*")
+	if err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range files {
+		if err := copyEntry(filepath.Join(ld.dir, name), filepath.Join(tmpDir, name)); err != nil {
+			return fmt.Errorf("copy %s: %w", name, err)
+		}
+	}
+
+	return snapshot.Commit(targetDir, tmpDir, version)
+}
+
+func copyEntry(src, dest string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.IsDir():
+		return os.MkdirAll(dest, info.Mode().Perm())
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dest)
+	case info.Mode().IsRegular():
+		return copyFile(src, dest, info.Mode().Perm())
+	default:
+		return nil // sockets, devices and so on
+	}
+}
+
+func copyFile(src, dest string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func hashFile(hash io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(hash, f)
+	return err
+}
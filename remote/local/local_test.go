@@ -0,0 +1,97 @@
+package local_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/reddec/git-pipe/remote"
+	"github.com/reddec/git-pipe/remote/local"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupported(t *testing.T) {
+	plain := t.TempDir()
+	repo := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(repo, ".git"), 0700))
+
+	assert.True(t, local.Supported("dir://"+plain))
+	assert.True(t, local.Supported("file://"+plain))
+	assert.False(t, local.Supported("file://"+repo))
+	assert.False(t, local.Supported("https://example.com/app.git"))
+}
+
+func TestDirectory_Poll(t *testing.T) {
+	source := t.TempDir()
+	target := filepath.Join(t.TempDir(), "app")
+	write := func(name, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(source, name)), 0700))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(source, name), []byte(content), 0600))
+	}
+
+	src, err := local.New("dir://" + source)
+	require.NoError(t, err)
+	poll := func() bool {
+		changed, err := src.Poll(context.Background(), target)
+		require.NoError(t, err)
+		return changed
+	}
+
+	write("Dockerfile", "FROM alpine")
+	write("static/index.html", "hello")
+	write(".git/HEAD", "ref: refs/heads/master")
+	assert.True(t, poll())
+	assert.FileExists(t, filepath.Join(target, "static", "index.html"))
+	assert.NoDirExists(t, filepath.Join(target, ".git"))
+
+	assert.False(t, poll())
+
+	write(".git/HEAD", "ref: refs/heads/dev")
+	assert.False(t, poll(), ".git directory should be ignored")
+
+	write("static/index.html", "world")
+	require.NoError(t, os.Remove(filepath.Join(source, "Dockerfile")))
+	assert.True(t, poll())
+	data, err := ioutil.ReadFile(filepath.Join(target, "static", "index.html"))
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+	assert.NoFileExists(t, filepath.Join(target, "Dockerfile"))
+}
+
+func TestDirectory_Checkout(t *testing.T) {
+	source := t.TempDir()
+	target := filepath.Join(t.TempDir(), "app")
+	ctx := context.Background()
+	src, err := local.New("dir://" + source)
+	require.NoError(t, err)
+	read := func(dir string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "Dockerfile"))
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(source, "Dockerfile"), []byte("FROM alpine"), 0600))
+	_, err = src.Poll(ctx, target)
+	require.NoError(t, err)
+	good, err := src.(remote.Versioned).Version(ctx, target)
+	require.NoError(t, err)
+	require.NotEmpty(t, good)
+	running, err := filepath.EvalSymlinks(target)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(source, "Dockerfile"), []byte("FROM busybox"), 0600))
+	changed, err := src.Poll(ctx, target)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "FROM busybox", read(target))
+	assert.Equal(t, "FROM alpine", read(running), "content of running version is kept")
+
+	require.NoError(t, src.(remote.Checkouter).Checkout(ctx, target, good))
+	assert.Equal(t, "FROM alpine", read(target))
+	version, err := src.(remote.Versioned).Version(ctx, target)
+	require.NoError(t, err)
+	assert.Equal(t, good, version)
+}
@@ -0,0 +1,160 @@
+// Package oci implements source which tracks prebuilt OCI (docker) image by digest, so packages are deployed
+// without local build.
+package oci
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/reddec/git-pipe/core"
+	"github.com/reddec/git-pipe/internal"
+	"github.com/reddec/git-pipe/remote"
+	"go.uber.org/zap"
+)
+
+const (
+	// Scheme of image URL (ex: oci://ghcr.io/example/app:1.2).
+	Scheme = "oci"
+
+	defaultRegistry = "docker.io"
+	defaultTag      = "latest"
+	filePermission  = 0600
+)
+
+// New image source. Username and password are used for private registries.
+func New(docker client.APIClient, rawURL string, credentials remote.Credentials) (remote.Source, error) {
+	name, tag, digest := parseReference(strings.TrimPrefix(rawURL, Scheme+"://"))
+	auth, err := registryAuth(credentials)
+	if err != nil {
+		return nil, err
+	}
+	registry, repo := defaultRegistry, name
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 && strings.ContainsAny(parts[0], ".:") { //nolint:gomnd
+		registry, repo = parts[0], parts[1]
+	}
+	fragment := tag
+	if digest != "" {
+		fragment = digest
+	}
+	return &Image{
+		docker: docker,
+		name:   name,
+		tag:    tag,
+		digest: digest,
+		auth:   auth,
+		ref:    url.URL{Scheme: Scheme, Host: registry, Path: "/" + repo, Fragment: fragment},
+	}, nil
+}
+
+// Image source writes pinned (by digest) reference to the image file (see core.ImageFile) in target directory.
+// Image is pulled during poll, so registry credentials are not needed for deployment.
+type Image struct {
+	docker client.APIClient
+	name   string
+	tag    string
+	digest string // pinned digest from URL, if set
+	auth   string
+	ref    url.URL
+}
+
+func (img *Image) Ref() url.URL {
+	return img.ref
+}
+
+func (img *Image) Poll(ctx context.Context, targetDir string) (bool, error) {
+	digest := img.digest
+	if digest == "" {
+		info, err := img.docker.DistributionInspect(ctx, img.name+":"+img.tag, img.auth)
+		if err != nil {
+			return false, fmt.Errorf("get digest of %s:%s: %w", img.name, img.tag, err)
+		}
+		digest = info.Descriptor.Digest.String()
+	}
+
+	current, err := img.Version(ctx, targetDir)
+	if err != nil {
+		return false, err
+	}
+	if current == digest {
+		return false, nil
+	}
+
+	pinned := img.name + "@" + digest
+	internal.LoggerFromContext(ctx).Info("pulling image", zap.String("image", pinned))
+	if err := img.pull(ctx, pinned); err != nil {
+		return false, err
+	}
+	return true, img.Checkout(ctx, targetDir, digest)
+}
+
+// Version is a digest of current image. Empty if nothing polled yet.
+func (img *Image) Version(_ context.Context, targetDir string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(targetDir, core.ImageFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read image file: %w", err)
+	}
+	ref := strings.TrimSpace(string(data))
+	return ref[strings.LastIndex(ref, "@")+1:], nil
+}
+
+// Checkout previously polled image digest.
+func (img *Image) Checkout(_ context.Context, targetDir string, version string) error {
+	err := ioutil.WriteFile(filepath.Join(targetDir, core.ImageFile), []byte(img.name+"@"+version+"\n"), filePermission)
+	if err != nil {
+		return fmt.Errorf("write image file: %w", err)
+	}
+	return nil
+}
+
+func (img *Image) pull(ctx context.Context, ref string) error {
+	stream, err := img.docker.ImagePull(ctx, ref, types.ImagePullOptions{RegistryAuth: img.auth})
+	if err != nil {
+		return fmt.Errorf("pull image %s: %w", ref, err)
+	}
+	defer stream.Close()
+	// pull is finished when stream is closed
+	if _, err := io.Copy(ioutil.Discard, stream); err != nil {
+		return fmt.Errorf("pull image %s: %w", ref, err)
+	}
+	return nil
+}
+
+// parseReference splits image reference to name, tag and digest. Default tag is latest.
+func parseReference(ref string) (name, tag, digest string) {
+	if idx := strings.Index(ref, "@"); idx >= 0 {
+		ref, digest = ref[:idx], ref[idx+1:]
+	}
+	name, tag = ref, defaultTag
+	if idx := strings.LastIndex(ref, ":"); idx > strings.LastIndex(ref, "/") {
+		name, tag = ref[:idx], ref[idx+1:]
+	}
+	return name, tag, digest
+}
+
+func registryAuth(credentials remote.Credentials) (string, error) {
+	if credentials.Username == "" && credentials.Password == "" {
+		return "", nil
+	}
+	data, err := json.Marshal(types.AuthConfig{
+		Username: credentials.Username,
+		Password: credentials.Password,
+	})
+	if err != nil {
+		return "", fmt.Errorf("encode registry auth: %w", err)
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,124 @@
+package oci_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+	"github.com/reddec/git-pipe/core"
+	"github.com/reddec/git-pipe/remote"
+	"github.com/reddec/git-pipe/remote/oci"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const latestDigest = "sha256:4c9e6bc3ed1d2e2f5a1b0bb9cf6b7a2e1d6f7c3e4b5a69788f7e6d5c4b3a2918"
+
+func TestNew_Ref(t *testing.T) {
+	cases := []struct {
+		url string
+		ref url.URL
+	}{
+		{"oci://nginx", url.URL{Scheme: "oci", Host: "docker.io", Path: "/nginx", Fragment: "latest"}},
+		{"oci://example/app", url.URL{Scheme: "oci", Host: "docker.io", Path: "/example/app", Fragment: "latest"}},
+		{"oci://ghcr.io/example/app:1.2", url.URL{Scheme: "oci", Host: "ghcr.io", Path: "/example/app", Fragment: "1.2"}},
+		{"oci://localhost:5000/app", url.URL{Scheme: "oci", Host: "localhost:5000", Path: "/app", Fragment: "latest"}},
+		{"oci://registry.example.com:5000/team/app:v1@sha256:abc", url.URL{Scheme: "oci", Host: "registry.example.com:5000", Path: "/team/app", Fragment: "sha256:abc"}},
+	}
+	for _, c := range cases {
+		src, err := oci.New(&fakeDocker{}, c.url, remote.Credentials{})
+		require.NoError(t, err)
+		assert.Equal(t, c.ref, src.Ref(), c.url)
+	}
+}
+
+func TestImage_Poll(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("tag resolved to digest", func(t *testing.T) {
+		docker := &fakeDocker{}
+		src, err := oci.New(docker, "oci://registry.example.com:5000/team/app:v1", remote.Credentials{Username: "deploy", Password: "secret"})
+		require.NoError(t, err)
+		dir := t.TempDir()
+
+		changed, err := src.Poll(ctx, dir)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, []string{"registry.example.com:5000/team/app:v1"}, docker.inspected)
+		assert.Equal(t, []string{"registry.example.com:5000/team/app@" + latestDigest}, docker.pulled)
+		assert.Equal(t, map[string]string{"username": "deploy", "password": "secret"}, decodeAuth(t, docker.auth))
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, core.ImageFile))
+		require.NoError(t, err)
+		assert.Equal(t, "registry.example.com:5000/team/app@"+latestDigest+"\n", string(data))
+		version, err := src.(remote.Versioned).Version(ctx, dir)
+		require.NoError(t, err)
+		assert.Equal(t, latestDigest, version)
+
+		changed, err = src.Poll(ctx, dir)
+		require.NoError(t, err)
+		assert.False(t, changed)
+		assert.Len(t, docker.pulled, 1, "same digest should not be pulled again")
+	})
+
+	t.Run("pinned digest", func(t *testing.T) {
+		docker := &fakeDocker{}
+		src, err := oci.New(docker, "oci://localhost:5000/app:v1@sha256:abc", remote.Credentials{})
+		require.NoError(t, err)
+
+		changed, err := src.Poll(ctx, t.TempDir())
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Empty(t, docker.inspected, "pinned digest should not be resolved")
+		assert.Equal(t, []string{"localhost:5000/app@sha256:abc"}, docker.pulled)
+		assert.Empty(t, docker.auth)
+	})
+
+	t.Run("default tag", func(t *testing.T) {
+		docker := &fakeDocker{}
+		src, err := oci.New(docker, "oci://example/app", remote.Credentials{})
+		require.NoError(t, err)
+
+		_, err = src.Poll(ctx, t.TempDir())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"example/app:latest"}, docker.inspected)
+	})
+}
+
+func decodeAuth(t *testing.T, auth string) map[string]string {
+	data, err := base64.URLEncoding.DecodeString(auth)
+	require.NoError(t, err)
+	var ans map[string]string
+	require.NoError(t, json.Unmarshal(data, &ans))
+	return ans
+}
+
+// fakeDocker resolves any tag to latestDigest and records pulls. Other methods are not implemented.
+type fakeDocker struct {
+	client.APIClient
+	inspected []string
+	pulled    []string
+	auth      string
+}
+
+func (fd *fakeDocker) DistributionInspect(_ context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	fd.inspected = append(fd.inspected, image)
+	var info registry.DistributionInspect
+	info.Descriptor.Digest = latestDigest
+	return info, nil
+}
+
+func (fd *fakeDocker) ImagePull(_ context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	fd.pulled = append(fd.pulled, ref)
+	fd.auth = options.RegistryAuth
+	return ioutil.NopCloser(strings.NewReader(`{"status":"done"}`)), nil
+}
@@ -0,0 +1,122 @@
+// Package snapshot keeps immutable versions of content for sources without history (archives, local directories).
+// Target directory is a symlink to the current version, so the running generation of package keeps using its own
+// version (bind mounts, build context) while the next one is prepared, and work dir could be rolled back.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Keep is number of the most recently used versions kept on disk.
+const Keep = 3
+
+const defaultPermission = 0755
+
+var ErrUnknownVersion = errors.New("unknown version")
+
+// Dir with versions of target dir content. It is hidden sibling of target dir.
+func Dir(targetDir string) string {
+	return filepath.Join(filepath.Dir(targetDir), "."+filepath.Base(targetDir)+".versions")
+}
+
+// Commit prepared content as version and switch target dir to it. Content dir is moved, so it should be on the same
+// file system as target dir. Content of already known version is not replaced.
+func Commit(targetDir, contentDir, version string) error {
+	versions := Dir(targetDir)
+	if err := os.MkdirAll(versions, defaultPermission); err != nil {
+		return fmt.Errorf("create versions dir: %w", err)
+	}
+	dest := filepath.Join(versions, version)
+	if !isDir(dest) {
+		if err := os.Rename(contentDir, dest); err != nil {
+			return fmt.Errorf("move content: %w", err)
+		}
+	}
+	return Checkout(targetDir, version)
+}
+
+// Checkout switches target dir to previously committed version. Old versions, except the most recently used, are
+// removed.
+func Checkout(targetDir, version string) error {
+	versions := Dir(targetDir)
+	dest := filepath.Join(versions, version)
+	if !isDir(dest) {
+		return fmt.Errorf("checkout %s: %w", version, ErrUnknownVersion)
+	}
+
+	link := filepath.Join(versions, ".current")
+	_ = os.Remove(link)
+	if err := os.Symlink(filepath.Join(filepath.Base(versions), version), link); err != nil {
+		return fmt.Errorf("create link: %w", err)
+	}
+	// work dir created before the first version (or by previous releases) is replaced by link
+	if info, err := os.Lstat(targetDir); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		if err := os.RemoveAll(targetDir); err != nil {
+			return fmt.Errorf("remove old content: %w", err)
+		}
+	}
+	if err := os.Rename(link, targetDir); err != nil {
+		return fmt.Errorf("switch version: %w", err)
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(dest, now, now); err != nil {
+		return fmt.Errorf("touch version: %w", err)
+	}
+	return prune(versions, version)
+}
+
+// Current version of target dir. Empty if nothing committed yet.
+func Current(targetDir string) (string, error) {
+	info, err := os.Lstat(targetDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("stat work dir: %w", err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return "", nil // regular dir, nothing committed yet
+	}
+	target, err := os.Readlink(targetDir)
+	if err != nil {
+		return "", fmt.Errorf("read current version: %w", err)
+	}
+	return filepath.Base(target), nil
+}
+
+// prune all versions except current and the most recently used.
+func prune(versions, current string) error {
+	list, err := ioutil.ReadDir(versions)
+	if err != nil {
+		return fmt.Errorf("list versions: %w", err)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ModTime().After(list[j].ModTime())
+	})
+	var kept = 1 // current
+	for _, info := range list {
+		if !info.IsDir() || info.Name() == current {
+			continue
+		}
+		if kept < Keep {
+			kept++
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(versions, info.Name())); err != nil {
+			return fmt.Errorf("remove version %s: %w", info.Name(), err)
+		}
+	}
+	return nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
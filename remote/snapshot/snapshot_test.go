@@ -0,0 +1,68 @@
+package snapshot_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/reddec/git-pipe/remote/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommit(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "app")
+	require.NoError(t, os.Mkdir(target, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(target, "stale"), nil, 0600))
+
+	current, err := snapshot.Current(target)
+	require.NoError(t, err)
+	assert.Empty(t, current, "regular dir has no version")
+
+	commit := func(version, content string) {
+		dir, err := ioutil.TempDir(root, ".content-*")
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte(content), 0600))
+		require.NoError(t, snapshot.Commit(target, dir, version))
+	}
+	read := func(dir string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "index.html"))
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	commit("v1", "first")
+	assert.Equal(t, "first", read(target))
+	assert.NoFileExists(t, filepath.Join(target, "stale"), "regular dir is replaced")
+	running, err := filepath.EvalSymlinks(target)
+	require.NoError(t, err)
+
+	commit("v2", "second")
+	assert.Equal(t, "second", read(target))
+	assert.Equal(t, "first", read(running), "content of running version is not touched")
+	current, err = snapshot.Current(target)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", current)
+
+	require.NoError(t, snapshot.Checkout(target, "v1"))
+	assert.Equal(t, "first", read(target))
+	current, err = snapshot.Current(target)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", current)
+
+	err = snapshot.Checkout(target, "v0")
+	assert.ErrorIs(t, err, snapshot.ErrUnknownVersion)
+
+	t.Run("old versions are removed", func(t *testing.T) {
+		for i := 3; i < 3+snapshot.Keep; i++ {
+			commit("v"+strconv.Itoa(i), "next")
+		}
+		list, err := ioutil.ReadDir(snapshot.Dir(target))
+		require.NoError(t, err)
+		assert.Len(t, list, snapshot.Keep)
+		assert.NoDirExists(t, running)
+	})
+}
@@ -0,0 +1,228 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/reddec/git-pipe/internal"
+	"github.com/reddec/git-pipe/remote"
+	"github.com/reddec/git-pipe/remote/git"
+	"go.uber.org/zap"
+)
+
+const maxPayloadSize = 10 * 1024 * 1024
+
+var (
+	ErrSignatureMismatch = errors.New("signature mismatch")
+	ErrSignatureMissing  = errors.New("signature missing")
+)
+
+// Target of webhook which can poll sources immediately.
+type Target interface {
+	// Trigger immediate poll for pipelines matched by filter. Returns names of triggered pipelines.
+	Trigger(filter func(name string, source remote.Source) bool) []string
+}
+
+// New webhook handler which triggers target pipelines by push events.
+// Supports GitHub, GitLab, Gitea and generic JSON payloads. Empty secret disables signature verification.
+//
+// Generic payload: {"repository": "<git url>", "ref": "refs/heads/<branch>", "name": "<repo name>"}, where
+// repository or name is required. Signature for generic payload is the same as for GitHub: X-Hub-Signature-256 header
+// with sha256=<hex HMAC-SHA256 of body> value.
+func New(secret string, target Target) http.Handler {
+	return &handler{secret: []byte(secret), target: target}
+}
+
+type handler struct {
+	secret []byte
+	target Target
+}
+
+func (wh *handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	logger := internal.SubLogger(request.Context(), "webhook")
+	if request.Method != http.MethodPost {
+		http.Error(writer, "", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, maxPayloadSize))
+	if err != nil {
+		http.Error(writer, "", http.StatusBadRequest)
+		logger.Info("failed read payload", zap.Error(err))
+		return
+	}
+
+	var provider = detect(request.Header)
+	logger = logger.With(zap.String("provider", provider.Name))
+
+	if err := wh.verify(provider, request.Header, body); err != nil {
+		http.Error(writer, "", http.StatusUnauthorized)
+		logger.Info("verification failed", zap.Error(err))
+		return
+	}
+
+	if !provider.Push(request.Header) {
+		writer.WriteHeader(http.StatusNoContent)
+		logger.Debug("event ignored")
+		return
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(writer, "", http.StatusBadRequest)
+		logger.Info("failed parse payload", zap.Error(err))
+		return
+	}
+
+	triggered := wh.target.Trigger(payload.Match)
+	logger.Info("push event received", zap.Strings("urls", payload.URLs()), zap.String("ref", payload.Ref), zap.Strings("triggered", triggered))
+
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode(triggered)
+}
+
+func (wh *handler) verify(provider provider, header http.Header, body []byte) error {
+	if len(wh.secret) == 0 {
+		return nil
+	}
+	if provider.Token != "" {
+		token := header.Get(provider.Token)
+		if token == "" {
+			return ErrSignatureMissing
+		}
+		if subtle.ConstantTimeCompare([]byte(token), wh.secret) != 1 {
+			return ErrSignatureMismatch
+		}
+		return nil
+	}
+
+	signature := header.Get(provider.Signature)
+	if signature == "" {
+		return ErrSignatureMissing
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, provider.Prefix))
+	if err != nil {
+		return ErrSignatureMismatch
+	}
+	mac := hmac.New(sha256.New, wh.secret)
+	_, _ = mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
+
+type provider struct {
+	Name      string // provider name
+	Event     string // header with event type
+	PushEvent string // event type for push
+	Signature string // header with HMAC-SHA256 signature
+	Prefix    string // signature prefix
+	Token     string // header with plain secret (instead of signature)
+}
+
+// Push returns true if request is push event.
+func (p provider) Push(header http.Header) bool {
+	if p.Event == "" {
+		return true
+	}
+	return strings.EqualFold(header.Get(p.Event), p.PushEvent)
+}
+
+func detect(header http.Header) provider {
+	switch {
+	case header.Get("X-Gitea-Event") != "":
+		return provider{Name: "gitea", Event: "X-Gitea-Event", PushEvent: "push", Signature: "X-Gitea-Signature"}
+	case header.Get("X-Gitlab-Event") != "":
+		return provider{Name: "gitlab", Event: "X-Gitlab-Event", PushEvent: "Push Hook", Token: "X-Gitlab-Token"}
+	case header.Get("X-GitHub-Event") != "":
+		return provider{Name: "github", Event: "X-GitHub-Event", PushEvent: "push", Signature: "X-Hub-Signature-256", Prefix: "sha256="}
+	default:
+		return provider{Name: "generic", Signature: "X-Hub-Signature-256", Prefix: "sha256="}
+	}
+}
+
+// Payload is union of fields from all supported providers.
+type Payload struct {
+	Ref        string `json:"ref"`
+	Name       string `json:"name"`       // generic
+	Repository urls   `json:"repository"` // github, gitea, gitlab, generic
+	Project    urls   `json:"project"`    // gitlab
+}
+
+// URLs of repository mentioned in payload.
+func (p *Payload) URLs() []string {
+	return append(p.Repository.URLs(), p.Project.URLs()...)
+}
+
+// Match pipeline by name or source URL and branch.
+func (p *Payload) Match(name string, source remote.Source) bool {
+	if p.Name != "" && p.Name == name {
+		return true
+	}
+	ref := source.Ref()
+	// URL without fragment follows the default branch
+	if branch := strings.TrimPrefix(p.Ref, "refs/heads/"); branch != p.Ref && git.ParseTarget(ref.Fragment).Branch != branch {
+		return false
+	}
+	expected := Normalize(ref.String())
+	for _, u := range p.URLs() {
+		if Normalize(u) == expected {
+			return true
+		}
+	}
+	return false
+}
+
+type urls struct {
+	URL        string `json:"url"`
+	CloneURL   string `json:"clone_url"`
+	SSHURL     string `json:"ssh_url"`
+	HTMLURL    string `json:"html_url"`
+	GitHTTPURL string `json:"git_http_url"`
+	GitSSHURL  string `json:"git_ssh_url"`
+	WebURL     string `json:"web_url"`
+	Homepage   string `json:"homepage"`
+	raw        string
+}
+
+func (u *urls) UnmarshalJSON(data []byte) error {
+	// generic payload: repository as plain string
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &u.raw)
+	}
+	type plain urls
+	return json.Unmarshal(data, (*plain)(u))
+}
+
+func (u *urls) URLs() []string {
+	var ans []string
+	for _, v := range []string{u.raw, u.URL, u.CloneURL, u.SSHURL, u.HTMLURL, u.GitHTTPURL, u.GitSSHURL, u.WebURL, u.Homepage} {
+		if v != "" {
+			ans = append(ans, v)
+		}
+	}
+	return ans
+}
+
+// Normalize git URL to comparable form: lower-cased host and path without scheme, credentials, port, fragment and .git suffix.
+// Supports SCP-like syntax (git@host:path).
+func Normalize(gitURL string) string {
+	if !strings.Contains(gitURL, "://") {
+		gitURL = "ssh://" + strings.Replace(gitURL, ":", "/", 1)
+	}
+	u, err := url.Parse(gitURL)
+	if err != nil {
+		return strings.ToLower(gitURL)
+	}
+	path := strings.Trim(u.Path, "/")
+	path = strings.TrimSuffix(path, ".git")
+	return strings.ToLower(u.Hostname() + "/" + path)
+}